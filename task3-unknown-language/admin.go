@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// validateAllPageSize - количество пользователей, читаемых за один запрос
+// при проверке всей таблицы
+const validateAllPageSize = 500
+
+// isConfirmed проверяет флаг подтверждения ?confirm=true для опасных операций
+func isConfirmed(r *http.Request) bool {
+	return r.URL.Query().Get("confirm") == "true"
+}
+
+// configHandler - действующая конфигурация сервера со скрытыми секретами
+func (s *Server) configHandler(w http.ResponseWriter, r *http.Request) error {
+	writeJSON(w, http.StatusOK, s.config.redacted())
+	return nil
+}
+
+// ageIncrementHandler - увеличение возраста всех пользователей на 1.
+// Операция выполняется в транзакции и отклоняется целиком, если хотя бы
+// один пользователь превысит максимальный возраст.
+func (s *Server) ageIncrementHandler(w http.ResponseWriter, r *http.Request) error {
+	if !isConfirmed(r) {
+		return badRequest("Confirmation required: pass ?confirm=true")
+	}
+
+	tx, err := s.store.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return dbError(err, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	var atLimit int
+	err = tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM users WHERE age >= ? AND "+notDeleted, maxUserAge).Scan(&atLimit)
+	if err != nil {
+		return dbError(err, "Failed to check ages")
+	}
+	if atLimit > 0 {
+		return apiError{
+			Status:  http.StatusConflict,
+			Code:    "age_limit_exceeded",
+			Message: fmt.Sprintf("%d users would exceed the maximum age of %d", atLimit, maxUserAge),
+		}
+	}
+
+	result, err := tx.ExecContext(r.Context(), "UPDATE users SET age = age + 1 WHERE "+notDeleted)
+	if err != nil {
+		return dbError(err, "Failed to update ages")
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err, "Failed to check update result")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return dbError(err, "Failed to commit transaction")
+	}
+	s.audit(r, auditAgeIncrement, 0, []string{"age"})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"updated": updated,
+	})
+	return nil
+}
+
+// validationReport - нарушения правил валидации у одного пользователя
+type validationReport struct {
+	ID     int      `json:"id"`
+	Errors []string `json:"errors"`
+}
+
+// validateAllHandler - проверка всех сохраненных пользователей по текущим
+// правилам валидации. Таблица читается страницами по id, а отчет пишется
+// потоково, поэтому пользователи не загружаются в память целиком.
+// Данные не изменяются.
+func (s *Server) validateAllHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	lang := requestLang(r)
+
+	// Первая страница читается до записи заголовков, чтобы ошибку базы
+	// можно было вернуть обычным ответом
+	users, err := s.store.queryUsers(ctx, "SELECT "+userColumns+" FROM users WHERE id > ? AND "+notDeleted+" ORDER BY id LIMIT ?",
+		0, validateAllPageSize)
+	if err != nil {
+		return dbError(err, "Failed to fetch users")
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Content-Language", lang)
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	checked, invalid := 0, 0
+	fmt.Fprint(w, `{"invalid":[`)
+	for len(users) > 0 {
+		for _, user := range users {
+			checked++
+			problems := s.validateUser(UserRequest{Name: user.Name, Email: user.Email, Username: user.Username, Age: user.Age})
+			if len(problems) == 0 {
+				continue
+			}
+
+			// Сверх ABSOLUTE_MAX_RESULTS отчеты только считаются
+			invalid++
+			if invalid > s.config.AbsoluteMaxResults {
+				continue
+			}
+
+			report := validationReport{ID: user.ID}
+			for _, m := range problems {
+				report.Errors = append(report.Errors, translate(lang, m))
+			}
+			if invalid > 1 {
+				fmt.Fprint(w, ",")
+			}
+			enc.Encode(report)
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		lastID := users[len(users)-1].ID
+		users, err = s.store.queryUsers(ctx, "SELECT "+userColumns+" FROM users WHERE id > ? AND "+notDeleted+" ORDER BY id LIMIT ?",
+			lastID, validateAllPageSize)
+		if err != nil {
+			// Статус уже отправлен: обрываем отчет, клиент получит некорректный JSON
+			log.Printf("Validate-all aborted after id %d: %v", lastID, err)
+			return nil
+		}
+	}
+	fmt.Fprintf(w, `],"checked":%d,"invalid_count":%d,"capped":%t}`+"\n", checked, invalid, invalid > s.config.AbsoluteMaxResults)
+	return nil
+}
+
+// DedupGroup - группа пользователей с одинаковым нормализованным email
+type DedupGroup struct {
+	Email   string `json:"email"`
+	Kept    int    `json:"kept"`
+	Removed []int  `json:"removed"`
+}
+
+// dedupHandler - мягкое удаление дубликатов пользователей по email без
+// учета регистра и пробелов по краям. В каждой группе остается самый ранний
+// пользователь, остальным проставляется deleted_at: записи остаются в базе,
+// и очистку можно отменить. Без ?confirm=true выполняется пробный прогон:
+// возвращается отчет, данные не изменяются.
+func (s *Server) dedupHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	dryRun := !isConfirmed(r)
+
+	tx, err := s.store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	// lower() в SQLite меняет регистр только у ASCII, что достаточно для email
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, lower(trim(email)) AS normalized FROM users
+		WHERE `+notDeleted+` AND lower(trim(email)) IN (
+			SELECT lower(trim(email)) FROM users WHERE `+notDeleted+` GROUP BY 1 HAVING COUNT(*) > 1
+		)
+		ORDER BY normalized, created_at, id`)
+	if err != nil {
+		return dbError(err, "Failed to find duplicates")
+	}
+
+	groups := []DedupGroup{}
+	var removedIDs []int
+	for rows.Next() {
+		var id int
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			rows.Close()
+			return dbError(err, "Failed to find duplicates")
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Email != email {
+			groups = append(groups, DedupGroup{Email: email, Kept: id, Removed: []int{}})
+			continue
+		}
+		last := &groups[len(groups)-1]
+		last.Removed = append(last.Removed, id)
+		removedIDs = append(removedIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return dbError(err, "Failed to find duplicates")
+	}
+
+	if !dryRun {
+		for _, id := range removedIDs {
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+				return dbError(err, "Failed to delete duplicates")
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return dbError(err, "Failed to commit transaction")
+		}
+		for _, id := range removedIDs {
+			s.audit(r, auditSoftDelete, id, []string{"deleted_at"})
+		}
+	}
+
+	// Обрабатываются все группы, но в отчете их не больше ABSOLUTE_MAX_RESULTS
+	limit, capped := s.capResults(len(groups))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dry_run": dryRun,
+		"groups":  groups[:limit],
+		"removed": len(removedIDs),
+		"capped":  capped,
+	})
+	return nil
+}
+
+// MergeRequest - запрос на слияние двух учетных записей
+type MergeRequest struct {
+	PrimaryID   int `json:"primary_id"`
+	SecondaryID int `json:"secondary_id"`
+}
+
+// mergeUsersHandler - слияние учетной записи secondary_id в primary_id.
+// Метки и журнал email переносятся на основную запись (при совпадении ключа
+// метки остается значение основной), вторая запись мягко удаляется через
+// deleted_at и может быть восстановлена. Все шаги выполняются в одной
+// транзакции.
+func (s *Server) mergeUsersHandler(w http.ResponseWriter, r *http.Request) error {
+	var req MergeRequest
+	if err := s.decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if req.PrimaryID < 1 || req.SecondaryID < 1 {
+		return badRequest("primary_id and secondary_id are required")
+	}
+	if req.PrimaryID == req.SecondaryID {
+		return badRequest("primary_id and secondary_id must differ")
+	}
+
+	ctx := r.Context()
+	tx, err := s.store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	for _, id := range []int{req.PrimaryID, req.SecondaryID} {
+		exists, err := userExists(ctx, tx, id)
+		if err != nil {
+			return dbError(err, "Failed to merge users")
+		}
+		if !exists {
+			return notFound(fmt.Sprintf("User %d not found", id))
+		}
+	}
+
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT OR IGNORE INTO user_labels (user_id, key, value) SELECT ?, key, value FROM user_labels WHERE user_id = ?",
+			[]interface{}{req.PrimaryID, req.SecondaryID}},
+		{"DELETE FROM user_labels WHERE user_id = ?",
+			[]interface{}{req.SecondaryID}},
+		{"UPDATE email_changes SET user_id = ? WHERE user_id = ?",
+			[]interface{}{req.PrimaryID, req.SecondaryID}},
+		{"UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?",
+			[]interface{}{req.SecondaryID}},
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step.query, step.args...); err != nil {
+			return dbError(err, "Failed to merge users")
+		}
+	}
+
+	merged, err := scanUser(tx.StmtContext(ctx, s.store.stmtGetUser).QueryRowContext(ctx, req.PrimaryID))
+	if err != nil {
+		return dbError(err, "Failed to fetch merged user")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError(err, "Failed to commit transaction")
+	}
+	s.audit(r, auditMerge, req.PrimaryID, []string{"labels"})
+	s.audit(r, auditSoftDelete, req.SecondaryID, []string{"deleted_at"})
+
+	writeJSON(w, http.StatusOK, s.withComputedFields(merged, time.Now()))
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxBatchOperations - максимальное количество операций в запросе /batch
+const maxBatchOperations = 100
+
+// BatchOperation - одна операция в запросе /batch
+type BatchOperation struct {
+	Op   string          `json:"op"`
+	ID   int             `json:"id,omitempty"`
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// BatchResult - результат одной операции /batch
+type BatchResult struct {
+	Index  int          `json:"index"`
+	Op     string       `json:"op"`
+	Status int          `json:"status"`
+	ID     int          `json:"id"`
+	User   *UserDetails `json:"user,omitempty"`
+
+	// changed - измененные поля для журнала аудита
+	changed []string
+}
+
+// batchHandler - выполнение упорядоченного списка операций create, update
+// и delete в одной транзакции. При ошибке любой операции транзакция
+// откатывается целиком, а в ответе указывается номер операции.
+func (s *Server) batchHandler(w http.ResponseWriter, r *http.Request) error {
+	body, err := s.readJSONArray(w, r)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	importer := s.config.AdminToken == "" || s.isAdmin(r)
+
+	// При блокировке базы транзакция повторяется целиком, как в
+	// createUserHandler; результаты прерванной попытки отбрасываются
+	var results []BatchResult
+	err = s.withRetry(ctx, func() error {
+		tx, err := s.store.db.BeginTx(ctx, nil)
+		if err != nil {
+			return dbError(err, "Failed to start transaction")
+		}
+		defer tx.Rollback()
+
+		// Операции декодируются и применяются по одной, поэтому в памяти
+		// не держится весь декодированный массив
+		results = nil
+		count, err := eachJSONArrayItem(body, func(i int, item json.RawMessage) error {
+			if i >= maxBatchOperations {
+				return badRequest(fmt.Sprintf("Batch must contain at most %d operations", maxBatchOperations))
+			}
+			var op BatchOperation
+			if err := json.Unmarshal(item, &op); err != nil {
+				return badRequest("Invalid JSON format")
+			}
+
+			result, err := s.applyBatchOperation(ctx, tx, op, importer, now)
+			if err != nil {
+				return batchOperationFailed(i, op, err)
+			}
+			result.Index = i
+			result.Op = op.Op
+			if result.User != nil {
+				details := s.withComputedFields(result.User.User, now)
+				result.User = &details
+			}
+			results = append(results, result)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return badRequest("Batch must contain at least one operation")
+		}
+
+		if err := tx.Commit(); err != nil {
+			return dbError(err, "Failed to commit transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		s.audit(r, result.Op, result.ID, result.changed)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+	})
+	return nil
+}
+
+// applyBatchOperation выполняет одну операцию в транзакции tx. importer
+// разрешает задавать created_at при создании для импорта исторических данных.
+func (s *Server) applyBatchOperation(ctx context.Context, tx *sql.Tx, op BatchOperation, importer bool, now time.Time) (BatchResult, error) {
+	switch op.Op {
+	case "create":
+		if s.config.StrictJSON && hasTopLevelField(op.Body, "id") {
+			return BatchResult{}, badRequest("id must not be provided on create")
+		}
+		userReq, err := s.parseUserRequest(op.Body)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		createdAt, err := parseImportCreatedAt(op.Body, importer, now)
+		if err != nil {
+			return BatchResult{}, err
+		}
+
+		var result sql.Result
+		if createdAt != "" {
+			result, err = tx.ExecContext(ctx,
+				"INSERT INTO users (name, email, username, age, created_at) VALUES (?, ?, NULLIF(?, ''), ?, ?)",
+				userReq.Name, userReq.Email, userReq.Username, userReq.Age, createdAt,
+			)
+		} else {
+			result, err = tx.StmtContext(ctx, s.store.stmtInsertUser).ExecContext(ctx, userReq.Name, userReq.Email, userReq.Username, userReq.Age)
+		}
+		if err != nil {
+			if isUniqueViolation(err) {
+				return BatchResult{}, uniqueConflict(err)
+			}
+			return BatchResult{}, dbError(err, "Failed to create user")
+		}
+		userID, err := result.LastInsertId()
+		if err != nil {
+			return BatchResult{}, dbError(err, "Failed to get user ID")
+		}
+		created, err := s.batchUserResult(ctx, tx, http.StatusCreated, int(userID))
+		created.changed = createdFieldNames(userReq)
+		return created, err
+
+	case "update":
+		if op.ID < 1 {
+			return BatchResult{}, badRequest("Invalid user ID")
+		}
+		userReq, err := s.parseUserRequest(op.Body)
+		if err != nil {
+			return BatchResult{}, err
+		}
+
+		previous, err := s.updateUserInTx(ctx, tx, op.ID, userReq)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return BatchResult{}, notFound("User not found")
+			}
+			if isUniqueViolation(err) {
+				return BatchResult{}, uniqueConflict(err)
+			}
+			return BatchResult{}, dbError(err, "Failed to update user")
+		}
+		updated, err := s.batchUserResult(ctx, tx, http.StatusOK, op.ID)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		updated.changed = changedFieldNames(previous, updated.User.User)
+		return updated, nil
+
+	case "delete":
+		if op.ID < 1 {
+			return BatchResult{}, badRequest("Invalid user ID")
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ? AND "+notDeleted, op.ID)
+		if err != nil {
+			return BatchResult{}, dbError(err, "Failed to delete user")
+		}
+		if err := requireAffected(result); err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{Status: http.StatusOK, ID: op.ID}, nil
+
+	default:
+		return BatchResult{}, badRequest("Invalid op: use create, update or delete")
+	}
+}
+
+// parseImportCreatedAt читает необязательный created_at из тела операции
+// create и возвращает его в формате SQLite. Поле принимается только от
+// администратора, должно быть в RFC 3339 и не может быть в будущем.
+func parseImportCreatedAt(body []byte, importer bool, now time.Time) (string, error) {
+	var fields struct {
+		CreatedAt *string `json:"created_at"`
+	}
+	if err := json.Unmarshal(body, &fields); err != nil || fields.CreatedAt == nil {
+		return "", nil
+	}
+	if !importer {
+		return "", unauthorized("Admin authorization required to set created_at")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, *fields.CreatedAt)
+	if err != nil {
+		return "", validationFailed([]message{newMessage("created_at_invalid")})
+	}
+	if createdAt.After(now) {
+		return "", validationFailed([]message{newMessage("created_at_future")})
+	}
+	return createdAt.UTC().Format(sqliteTimeFormat), nil
+}
+
+// requireAffected возвращает 404, если операция не затронула ни одной строки
+func requireAffected(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err, "Failed to check result")
+	}
+	if rowsAffected == 0 {
+		return notFound("User not found")
+	}
+	return nil
+}
+
+// batchUserResult читает пользователя внутри транзакции для результата операции
+func (s *Server) batchUserResult(ctx context.Context, tx *sql.Tx, status, userID int) (BatchResult, error) {
+	user, err := scanUser(tx.StmtContext(ctx, s.store.stmtGetUser).QueryRowContext(ctx, userID))
+	if err != nil {
+		return BatchResult{}, dbError(err, "Failed to fetch user")
+	}
+	return BatchResult{Status: status, ID: userID, User: &UserDetails{User: user}}, nil
+}
+
+// batchOperationFailed дополняет ошибку операции ее номером; статус и код
+// сохраняются, чтобы клиент видел причину отката
+func batchOperationFailed(index int, op BatchOperation, err error) error {
+	var apiErr apiError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	apiErr.Details = append([]string{
+		fmt.Sprintf("operation %d (%s) failed; no changes were applied", index, op.Op),
+	}, apiErr.Details...)
+	return apiErr
+}
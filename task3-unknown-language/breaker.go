@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Состояния предохранителя базы данных; значения экспортируются в /metrics
+const (
+	breakerClosed   = 0
+	breakerHalfOpen = 1
+	breakerOpen     = 2
+)
+
+// circuitBreaker размыкается после BREAKER_THRESHOLD ошибок базы подряд
+// и на BREAKER_COOLDOWN сразу отвечает 503, не нагружая базу. После паузы
+// один запрос становится пробным: перед ним выполняется проверочный запрос
+// к базе, успех которого замыкает предохранитель, а ошибка снова размыкает.
+// Ответ обработчика для замыкания не учитывается: маршрут без обращения к
+// базе или ответ из кеша не доказывают, что база восстановилась.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreaker создает замкнутый предохранитель; порог 0 отключает его
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow сообщает, можно ли выполнять запрос к базе; probe - запрос должен
+// сначала проверить базу и сообщить результат через recovered или failure
+func (b *circuitBreaker) allow() (allowed, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false, false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		log.Println("Database circuit breaker half-open, probing")
+		return true, true
+	case breakerHalfOpen:
+		// Пока проверка не завершилась, остальные запросы отклоняются
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// success сбрасывает счетчик ошибок замкнутого предохранителя. Разомкнутый
+// так не замыкается: запрос мог начаться до размыкания.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerClosed {
+		b.failures = 0
+	}
+}
+
+// recovered замыкает предохранитель после успешной проверки базы
+func (b *circuitBreaker) recovered() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		log.Println("Database circuit breaker closed")
+	}
+	b.state = breakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// failure учитывает ошибку базы и размыкает предохранитель при достижении
+// порога или при неудаче пробного запроса
+func (b *circuitBreaker) failure() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state != breakerOpen {
+			log.Printf("Database circuit breaker open after %d consecutive failures", b.failures)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.probing = false
+	}
+}
+
+// currentState возвращает состояние для метрики
+func (b *circuitBreaker) currentState() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return float64(b.state)
+}
+
+// statusWriter запоминает код ответа обработчика
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader сохраняет код ответа
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush пробрасывает сброс буфера для потоковых ответов
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// breakerMiddleware отклоняет запросы с 503 circuit_open, пока предохранитель
+// разомкнут, и проверяет базу перед пробным запросом. Ошибки учитывает
+// apiHandler, а ответ без 5xx сбрасывает счетчик ошибок подряд. Служебные
+// эндпоинты не затрагиваются, чтобы /health показывал реальное состояние базы.
+func (s *Server) breakerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, probe := s.breaker.allow()
+		if allowed && probe {
+			if err := s.store.probe(r.Context()); err != nil {
+				log.Printf("Database circuit breaker probe failed: %v", err)
+				s.breaker.failure()
+				allowed = false
+			} else {
+				s.breaker.recovered()
+			}
+		}
+		if !allowed {
+			s.writeError(w, r, serviceUnavailable("circuit_open",
+				"Database is temporarily unavailable after repeated failures, please retry"))
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status < http.StatusInternalServerError {
+			s.breaker.success()
+		}
+	})
+}
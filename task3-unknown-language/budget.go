@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// budgetMiddleware ограничивает время обработки запроса бюджетом
+// REQUEST_BUDGET через дедлайн контекста
+func (s *Server) budgetMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.config.RequestBudget)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// budgetExceeded - ответ при исчерпании бюджета времени запроса (503)
+func budgetExceeded() apiError {
+	return serviceUnavailable("budget_exceeded", "Request time budget exceeded")
+}
+
+// checkBudget вызывается перед дорогими шагами обработки и прерывает запрос,
+// если до дедлайна осталось меньше десятой части бюджета: шаг почти наверняка
+// не успеет, и честный 503 лучше ответа после таймаута клиента
+func (s *Server) checkBudget(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+	if !ok || s.config.RequestBudget <= 0 {
+		return nil
+	}
+	if time.Until(deadline) < s.config.RequestBudget/10 {
+		return budgetExceeded()
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// cachedResponse - закешированный успешный ответ
+type cachedResponse struct {
+	key         string
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// responseCache кеширует успешные ответы GET на STATS_CACHE_TTL по пути и
+// параметрам запроса. Записи не инвалидируются заранее - устаревание по TTL
+// достаточно для дашбордов, опрашивающих агрегаты. Параметры запроса
+// произвольны, поэтому записей не больше maxEntries: сверх них вытесняется
+// та, к которой дольше всего не обращались.
+type responseCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// newResponseCache создает кеш с временем жизни записей ttl и не более
+// maxEntries записями; ttl 0 отключает кеш
+func newResponseCache(ttl time.Duration, maxEntries int) *responseCache {
+	return &responseCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// cacheRecorder сохраняет копию ответа, пропуская его клиенту
+type cacheRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader запоминает статус ответа
+func (rec *cacheRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write копирует тело ответа
+func (rec *cacheRecorder) Write(p []byte) (int, error) {
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// middleware отдает ответ из кеша, если он не устарел. ?fresh=true
+// пересчитывает ответ в обход кеша и обновляет запись.
+func (c *responseCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.ttl <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		fresh := query.Get("fresh") == "true"
+		query.Del("fresh")
+		key := cacheKey(r.URL.Path, query)
+
+		if !fresh {
+			if entry, ok := c.get(key); ok {
+				w.Header().Set("Content-Type", entry.contentType)
+				w.Header().Set("X-Cache", "HIT")
+				w.WriteHeader(http.StatusOK)
+				w.Write(entry.body)
+				return
+			}
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		rec := &cacheRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status == http.StatusOK {
+			c.set(cachedResponse{
+				key:         key,
+				contentType: w.Header().Get("Content-Type"),
+				body:        rec.body.Bytes(),
+				expires:     time.Now().Add(c.ttl),
+			})
+		}
+	})
+}
+
+// get возвращает неустаревшую запись кеша
+func (c *responseCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return cachedResponse{}, false
+	}
+	entry := elem.Value.(cachedResponse)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return cachedResponse{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+// set сохраняет запись и вытесняет самые давние сверх maxEntries
+func (c *responseCache) set(entry cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove удаляет запись; вызывается под c.mu
+func (c *responseCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(cachedResponse).key)
+}
+
+// len возвращает количество записей в кеше
+func (c *responseCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// cacheKey строит ключ из пути и параметров; Encode сортирует параметры,
+// поэтому их порядок в URL не влияет на ключ
+func cacheKey(path string, query url.Values) string {
+	return path + "?" + query.Encode()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultCacheControl - Cache-Control для маршрутов без правила, записи
+// и ошибок: без явного правила ответ не кешируется
+const defaultCacheControl = "no-store"
+
+// cacheControlRules разбирает CACHE_CONTROL: правила через ';', каждое в
+// виде шаблон=директивы, например /users/{id}=private, max-age=30
+func (c Config) cacheControlRules() (map[string]string, error) {
+	rules := make(map[string]string)
+	for _, rule := range strings.Split(c.CacheControl, ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		template, directives, ok := strings.Cut(rule, "=")
+		template, directives = strings.TrimSpace(template), strings.TrimSpace(directives)
+		if !ok || !strings.HasPrefix(template, "/") || directives == "" {
+			return nil, fmt.Errorf("CACHE_CONTROL: rule %q must look like /route/{template}=directives", rule)
+		}
+		rules[template] = directives
+	}
+	return rules, nil
+}
+
+// routeTemplate возвращает шаблон маршрута запроса (/users/{id}) или путь,
+// если маршрут не найден
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// cacheControlMiddleware выставляет Cache-Control по шаблону маршрута из
+// CACHE_CONTROL. Правила действуют только на GET и HEAD; запись и маршруты
+// без правила получают no-store. Ошибки writeError всегда отдает с no-store.
+func cacheControlMiddleware(rules map[string]string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := defaultCacheControl
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				if directives, ok := rules[routeTemplate(r)]; ok {
+					value = directives
+				}
+			}
+			w.Header().Set("Cache-Control", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
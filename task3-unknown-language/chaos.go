@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// chaosMiddleware внедряет случайные задержки и ошибки для проверки
+// устойчивости клиентов. Подключается только при CHAOS_MODE=true и
+// запрещен в production. Служебные эндпоинты не затрагиваются.
+func (s *Server) chaosMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.config.ChaosLatencyMax > 0 && rand.Float64() < s.config.ChaosLatencyRate {
+			delay := time.Duration(rand.Int63n(int64(s.config.ChaosLatencyMax)))
+			select {
+			case <-r.Context().Done():
+				// Задержка исчерпала бюджет запроса (504) или клиент отключился (499)
+				s.writeError(w, r, r.Context().Err())
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		if rand.Float64() < s.config.ChaosErrorRate {
+			s.writeError(w, r, apiError{
+				Status:  http.StatusInternalServerError,
+				Code:    "chaos_injected",
+				Message: "Injected failure (chaos mode)",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
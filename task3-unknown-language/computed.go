@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// gravatarBaseURL - адрес сервиса аватаров в стиле Gravatar
+const gravatarBaseURL = "https://www.gravatar.com/avatar/"
+
+// UserDetails - пользователь с вычисляемыми полями для ответов с одной записью.
+// Поля вычисляются при формировании ответа, не хранятся в базе и не
+// принимаются в UserRequest.
+type UserDetails struct {
+	User
+	AccountAgeDays int    `json:"account_age_days"`
+	AvatarURL      string `json:"avatar_url,omitempty"`
+}
+
+// withComputedFields дополняет пользователя вычисляемыми полями на момент now
+func (s *Server) withComputedFields(user User, now time.Time) UserDetails {
+	details := UserDetails{
+		User:           user,
+		AccountAgeDays: accountAgeDays(user.CreatedAt, now),
+	}
+	if s.config.AvatarURLs {
+		details.AvatarURL = avatarURL(user.Email)
+	}
+	return details
+}
+
+// accountAgeDays возвращает количество полных суток с момента регистрации
+func accountAgeDays(createdAt, now time.Time) int {
+	if now.Before(createdAt) {
+		return 0
+	}
+	return int(now.Sub(createdAt) / (24 * time.Hour))
+}
+
+// avatarURL строит адрес аватара по MD5 нормализованного email
+func avatarURL(email string) string {
+	sum := md5.Sum([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return gravatarBaseURL + hex.EncodeToString(sum[:])
+}
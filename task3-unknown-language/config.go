@@ -0,0 +1,638 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config содержит настройки сервера, читаемые из переменных окружения
+type Config struct {
+	// Env - окружение развертывания (development, production)
+	Env string
+
+	// AdminToken - токен для административных эндпоинтов.
+	// Если не задан, административные эндпоинты открыты.
+	AdminToken string `secret:"true" env:"ADMIN_TOKEN"`
+
+	// TrustProxy разрешает брать схему и хост из X-Forwarded-Proto и
+	// X-Forwarded-Host при построении абсолютных URL
+	TrustProxy bool
+
+	// DisabledMethods - HTTP-методы, отклоняемые с 405 на всех маршрутах,
+	// например POST, PUT, PATCH, DELETE для зеркала только на чтение
+	DisabledMethods []string
+
+	// H2C включает HTTP/2 без TLS (h2c) вместо HTTP/1.1
+	H2C bool
+
+	// HTTPKeepAlive разрешает повторное использование HTTP-соединений
+	HTTPKeepAlive bool
+
+	// TCPKeepAlive и TCPKeepAlivePeriod управляют TCP keep-alive принятых соединений
+	TCPKeepAlive       bool
+	TCPKeepAlivePeriod time.Duration
+
+	// IdleTimeout - время ожидания следующего запроса на keep-alive соединении
+	IdleTimeout time.Duration
+
+	// ShutdownTimeout - время на завершение активных запросов при остановке
+	ShutdownTimeout time.Duration
+
+	// GzipLevel - уровень сжатия ответов (1-9)
+	GzipLevel int
+
+	// GzipMinBytes - минимальный размер ответа для сжатия
+	GzipMinBytes int
+
+	// MaxBodyBytes - максимальный размер тела запроса
+	MaxBodyBytes int64
+
+	// MaxErrorDetails - максимальное количество деталей в ответе об ошибке
+	MaxErrorDetails int
+
+	// ProblemJSON отдает ошибки в формате RFC 7807 (application/problem+json)
+	// всем клиентам, а не только запросившим его в Accept
+	ProblemJSON bool
+
+	// StrictJSON включает строгую проверку тела запроса: например,
+	// отклоняет поле id при создании пользователя
+	StrictJSON bool
+
+	// JSONMaxDepth - максимальная вложенность JSON в теле запроса
+	JSONMaxDepth int
+
+	// StrictQuery отклоняет неизвестные параметры запроса на эндпоинтах списков
+	StrictQuery bool
+
+	// NameMinLen и NameMaxLen - допустимая длина имени в символах
+	NameMinLen int
+	NameMaxLen int
+
+	// NameCollation - сравнение имен при сортировке и поиске: nocase (без
+	// учета регистра латиницы) или binary (побайтно)
+	NameCollation string
+
+	// EmailMaxLen - максимальная длина email в символах (RFC 5321)
+	EmailMaxLen int
+
+	// EmailAllowedDomains - если задан, email допускается только в этих доменах;
+	// EmailBlockedDomains - запрещенные домены. Правило действует и на поддомены.
+	EmailAllowedDomains []string
+	EmailBlockedDomains []string
+
+	// ImmutableFields - поля пользователя, которые нельзя менять после создания
+	ImmutableFields []string
+
+	// AvatarURLs включает поле avatar_url в ответах с одним пользователем
+	AvatarURLs bool
+
+	// AbsoluteMaxResults - наибольшее количество записей в ответе любого
+	// эндпоинта списков независимо от запрошенного
+	AbsoluteMaxResults int
+
+	// Delete204 возвращает 204 No Content без тела при успешном удалении
+	Delete204 bool
+
+	// DBParams - дополнительные параметры строки подключения SQLite,
+	// например _cache_size=-20000; допускаются только ключи из allowedDBParams
+	DBParams string
+
+	// TenantDir - каталог баз арендаторов <id>.db (пусто отключает
+	// разделение по арендаторам). Арендатор берется из заголовка
+	// TenantHeader или поддомена TenantDomain.
+	TenantDir    string
+	TenantHeader string
+	TenantDomain string
+
+	// TenantMaxOpen - сколько баз арендаторов держать открытыми одновременно
+	TenantMaxOpen int
+
+	// TenantAutoCreate создает базу при первом обращении нового арендатора;
+	// иначе неизвестный арендатор получает 404
+	TenantAutoCreate bool
+
+	// ReadDBPath и ReadDSN задают реплику для запросов на чтение
+	ReadDBPath string
+	ReadDSN    string `secret:"true" env:"READ_DSN"`
+
+	// RequestBudget - бюджет времени обработки запроса (0 отключает)
+	RequestBudget time.Duration
+
+	// DBRetryAttempts и DBRetryBackoff управляют повтором записи при блокировке базы
+	DBRetryAttempts int
+	DBRetryBackoff  time.Duration
+
+	// BreakerThreshold - количество ошибок базы подряд, после которого
+	// предохранитель размыкается (0 отключает); BreakerCooldown - время
+	// до пробного запроса
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// DiskMinFreeBytes - минимум свободного места на томе с базой, ниже
+	// которого /health отвечает 503 (0, по умолчанию, отключает проверку)
+	DiskMinFreeBytes int64
+
+	// RetryAfter - значение Retry-After для ответов 503
+	RetryAfter time.Duration
+
+	// CacheControl - правила Cache-Control по шаблонам маршрутов через ';',
+	// например /users/{id}=private, max-age=30
+	CacheControl string
+
+	// StatsCacheTTL - время кеширования ответов /stats (0 отключает кеш);
+	// StatsCacheMaxEntries - наибольшее количество закешированных ответов
+	StatsCacheTTL        time.Duration
+	StatsCacheMaxEntries int
+
+	// OTLPEndpoint - адрес приема спанов OpenTelemetry (пусто отключает трассировку)
+	OTLPEndpoint string
+
+	// AuditLog - файл журнала аудита операций записи (пусто отключает журнал);
+	// AuditLogMaxBytes - размер файла для ротации (0 отключает ротацию),
+	// AuditLogBackups - сколько ротированных файлов хранить
+	AuditLog         string
+	AuditLogMaxBytes int64
+	AuditLogBackups  int
+
+	// MetricsCacheTTL - время кеширования бизнес-метрик
+	MetricsCacheTTL time.Duration
+
+	// DebugSQL добавляет в ответ списка блок _debug с текстом SQL и параметрами
+	DebugSQL bool
+
+	// ChaosMode включает внедрение случайных задержек и ошибок
+	ChaosMode        bool
+	ChaosLatencyRate float64
+	ChaosLatencyMax  time.Duration
+	ChaosErrorRate   float64
+}
+
+// loadConfig читает конфигурацию из переменных окружения
+func loadConfig() (Config, error) {
+	cfg := Config{
+		Env:          getEnv("ENV", "development"),
+		AdminToken:   os.Getenv("ADMIN_TOKEN"),
+		TrustProxy:   getEnvBool("TRUST_PROXY", false),
+		H2C:          getEnvBool("H2C", false),
+		GzipLevel:    getEnvInt("GZIP_LEVEL", 5),
+		GzipMinBytes: getEnvInt("GZIP_MIN_BYTES", 1024),
+		MaxBodyBytes: int64(getEnvInt("MAX_BODY_BYTES", 1<<20)),
+		ProblemJSON:  getEnvBool("PROBLEM_JSON", false),
+		StrictJSON:   getEnvBool("STRICT_JSON", false),
+		JSONMaxDepth: getEnvInt("JSON_MAX_DEPTH", 4),
+		StrictQuery:  getEnvBool("STRICT_QUERY", false),
+		NameMinLen:   getEnvInt("NAME_MIN_LEN", 1),
+		NameMaxLen:   getEnvInt("NAME_MAX_LEN", 100),
+		EmailMaxLen:  getEnvInt("EMAIL_MAX_LEN", 254),
+		AvatarURLs:   getEnvBool("AVATAR_URLS", true),
+
+		DisabledMethods:     getEnvList("DISABLED_METHODS"),
+		NameCollation:       getEnv("NAME_COLLATION", "nocase"),
+		EmailAllowedDomains: getEnvList("EMAIL_ALLOWED_DOMAINS"),
+		EmailBlockedDomains: getEnvList("EMAIL_BLOCKED_DOMAINS"),
+
+		ImmutableFields:    getEnvList("IMMUTABLE_FIELDS"),
+		AbsoluteMaxResults: getEnvInt("ABSOLUTE_MAX_RESULTS", 10000),
+		Delete204:          getEnvBool("DELETE_204", false),
+		DBParams:           os.Getenv("DB_PARAMS"),
+		ReadDBPath:         os.Getenv("READ_DB_PATH"),
+		ReadDSN:            os.Getenv("READ_DSN"),
+
+		TenantDir:        os.Getenv("TENANT_DIR"),
+		TenantHeader:     getEnv("TENANT_HEADER", "X-Tenant-ID"),
+		TenantDomain:     strings.ToLower(os.Getenv("TENANT_DOMAIN")),
+		TenantMaxOpen:    getEnvInt("TENANT_MAX_OPEN", 16),
+		TenantAutoCreate: getEnvBool("TENANT_AUTO_CREATE", false),
+
+		HTTPKeepAlive:      getEnvBool("HTTP_KEEP_ALIVE", true),
+		TCPKeepAlive:       getEnvBool("TCP_KEEP_ALIVE", true),
+		TCPKeepAlivePeriod: getEnvDuration("TCP_KEEP_ALIVE_PERIOD", 15*time.Second),
+		IdleTimeout:        getEnvDuration("IDLE_TIMEOUT", 60*time.Second),
+		ShutdownTimeout:    getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		RequestBudget:      getEnvDuration("REQUEST_BUDGET", 0),
+
+		DBRetryAttempts: getEnvInt("DB_RETRY_ATTEMPTS", 3),
+		DBRetryBackoff:  getEnvDuration("DB_RETRY_BACKOFF", 10*time.Millisecond),
+		RetryAfter:      getEnvDuration("RETRY_AFTER", 5*time.Second),
+
+		DiskMinFreeBytes: int64(getEnvInt("DISK_MIN_FREE_BYTES", 0)),
+
+		BreakerThreshold: getEnvInt("BREAKER_THRESHOLD", 0),
+		BreakerCooldown:  getEnvDuration("BREAKER_COOLDOWN", 10*time.Second),
+
+		MetricsCacheTTL:      getEnvDuration("METRICS_CACHE_TTL", 15*time.Second),
+		StatsCacheTTL:        getEnvDuration("STATS_CACHE_TTL", 30*time.Second),
+		StatsCacheMaxEntries: getEnvInt("STATS_CACHE_MAX_ENTRIES", 256),
+		CacheControl:         os.Getenv("CACHE_CONTROL"),
+		MaxErrorDetails:      getEnvInt("MAX_ERROR_DETAILS", 50),
+
+		DebugSQL:     getEnvBool("DEBUG_SQL", false),
+		OTLPEndpoint: os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+
+		AuditLog:         os.Getenv("AUDIT_LOG"),
+		AuditLogMaxBytes: int64(getEnvInt("AUDIT_LOG_MAX_BYTES", 10<<20)),
+		AuditLogBackups:  getEnvInt("AUDIT_LOG_BACKUPS", 5),
+
+		ChaosMode:        getEnvBool("CHAOS_MODE", false),
+		ChaosLatencyRate: getEnvFloat("CHAOS_LATENCY_RATE", 0),
+		ChaosLatencyMax:  getEnvDuration("CHAOS_LATENCY_MAX", time.Second),
+		ChaosErrorRate:   getEnvFloat("CHAOS_ERROR_RATE", 0),
+	}
+	return cfg, cfg.validate()
+}
+
+// validate проверяет корректность значений конфигурации
+func (c Config) validate() error {
+	if c.GzipLevel < gzip.BestSpeed || c.GzipLevel > gzip.BestCompression {
+		return fmt.Errorf("GZIP_LEVEL must be between %d and %d", gzip.BestSpeed, gzip.BestCompression)
+	}
+	if c.GzipMinBytes < 0 {
+		return fmt.Errorf("GZIP_MIN_BYTES must be non-negative")
+	}
+	if c.NameMinLen < 1 {
+		return fmt.Errorf("NAME_MIN_LEN must be at least 1")
+	}
+	if c.NameMaxLen < c.NameMinLen {
+		return fmt.Errorf("NAME_MAX_LEN must not be less than NAME_MIN_LEN")
+	}
+	if c.NameCollation != "nocase" && c.NameCollation != "binary" {
+		return fmt.Errorf("NAME_COLLATION must be nocase or binary")
+	}
+	if c.MaxErrorDetails < 1 {
+		return fmt.Errorf("MAX_ERROR_DETAILS must be at least 1")
+	}
+	if c.EmailMaxLen < 1 {
+		return fmt.Errorf("EMAIL_MAX_LEN must be at least 1")
+	}
+	for _, method := range c.DisabledMethods {
+		if !isRoutedMethod(method) {
+			return fmt.Errorf("DISABLED_METHODS: unknown method %q (use %s)", method, strings.Join(methodOrder, ", "))
+		}
+	}
+	for _, field := range c.ImmutableFields {
+		if !updatableUserFields[field] {
+			return fmt.Errorf("IMMUTABLE_FIELDS: unknown field %q (use name, email, username or age)", field)
+		}
+	}
+	if _, err := c.dbParams(); err != nil {
+		return err
+	}
+	if c.AbsoluteMaxResults < 1 {
+		return fmt.Errorf("ABSOLUTE_MAX_RESULTS must be at least 1")
+	}
+	if c.TenantDir != "" && c.TenantMaxOpen < 1 {
+		return fmt.Errorf("TENANT_MAX_OPEN must be at least 1")
+	}
+	if c.TenantDir != "" && c.ReadDSN+c.ReadDBPath != "" {
+		return fmt.Errorf("READ_DB_PATH and READ_DSN are not supported with TENANT_DIR")
+	}
+	if c.DBRetryAttempts < 1 {
+		return fmt.Errorf("DB_RETRY_ATTEMPTS must be at least 1")
+	}
+	if c.DBRetryBackoff <= 0 {
+		return fmt.Errorf("DB_RETRY_BACKOFF must be positive")
+	}
+	if c.TCPKeepAlivePeriod < 0 || c.IdleTimeout < 0 {
+		return fmt.Errorf("TCP_KEEP_ALIVE_PERIOD and IDLE_TIMEOUT must be non-negative")
+	}
+	if c.RequestBudget < 0 {
+		return fmt.Errorf("REQUEST_BUDGET must be non-negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("SHUTDOWN_TIMEOUT must be positive")
+	}
+	if c.BreakerThreshold < 0 {
+		return fmt.Errorf("BREAKER_THRESHOLD must be non-negative")
+	}
+	if c.BreakerCooldown <= 0 {
+		return fmt.Errorf("BREAKER_COOLDOWN must be positive")
+	}
+	if c.DiskMinFreeBytes < 0 {
+		return fmt.Errorf("DISK_MIN_FREE_BYTES must be non-negative")
+	}
+	if c.RetryAfter <= 0 {
+		return fmt.Errorf("RETRY_AFTER must be positive")
+	}
+	if c.StatsCacheTTL < 0 {
+		return fmt.Errorf("STATS_CACHE_TTL must be non-negative")
+	}
+	if c.StatsCacheMaxEntries < 1 {
+		return fmt.Errorf("STATS_CACHE_MAX_ENTRIES must be at least 1")
+	}
+	if _, err := c.cacheControlRules(); err != nil {
+		return err
+	}
+	if c.AuditLogMaxBytes < 0 || c.AuditLogBackups < 0 {
+		return fmt.Errorf("AUDIT_LOG_MAX_BYTES and AUDIT_LOG_BACKUPS must be non-negative")
+	}
+	// Пустой токен открывает административные эндпоинты, а короткий
+	// обычно оказывается заглушкой из примера (secret, changeme)
+	if c.isProduction() && len(c.AdminToken) < minSecretLen {
+		return fmt.Errorf("ADMIN_TOKEN must be at least %d characters in production", minSecretLen)
+	}
+	if c.isProduction() {
+		if err := c.checkSecrets(); err != nil {
+			return err
+		}
+	}
+	if c.ChaosMode && c.isProduction() {
+		return fmt.Errorf("CHAOS_MODE must not be enabled in production")
+	}
+	if c.ChaosLatencyRate < 0 || c.ChaosLatencyRate > 1 || c.ChaosErrorRate < 0 || c.ChaosErrorRate > 1 {
+		return fmt.Errorf("CHAOS_LATENCY_RATE and CHAOS_ERROR_RATE must be between 0 and 1")
+	}
+	if c.MaxBodyBytes <= 0 {
+		return fmt.Errorf("MAX_BODY_BYTES must be positive")
+	}
+	if c.JSONMaxDepth < 1 {
+		return fmt.Errorf("JSON_MAX_DEPTH must be at least 1")
+	}
+	return nil
+}
+
+// minSecretLen - минимальная длина ADMIN_TOKEN в production
+const minSecretLen = 16
+
+// placeholderSecrets - значения из примеров и шаблонов, которые не должны
+// попадать в production. Сравниваются без учета регистра, разделителей и
+// цифр в конце: Change-Me, CHANGEME и changeme123 считаются одной заглушкой.
+var placeholderSecrets = map[string]bool{
+	"admin": true, "administrator": true, "changeit": true, "changeme": true,
+	"default": true, "demo": true, "dev": true, "example": true, "insecure": true,
+	"letmein": true, "pass": true, "passw0rd": true, "password": true,
+	"placeholder": true, "qwerty": true, "replaceme": true, "root": true,
+	"secret": true, "secretkey": true, "test": true, "token": true, "todo": true,
+	"adminsecret": true, "admintoken": true, "mysecret": true, "supersecret": true,
+	"yoursecret": true, "yoursecrethere": true, "yourtoken": true, "yourtokenhere": true,
+}
+
+// checkSecrets проверяет заданные поля с тегом secret:"true": значение не
+// должно быть заглушкой. Сам секрет в текст ошибки не попадает.
+func (c Config) checkSecrets() error {
+	v := reflect.ValueOf(c)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("secret") != "true" {
+			continue
+		}
+		if value := v.Field(i).String(); value != "" && isPlaceholderSecret(value) {
+			return fmt.Errorf("%s looks like a placeholder or default value (e.g. secret, changeme, repeated characters); set a real secret in production", field.Tag.Get("env"))
+		}
+	}
+	return nil
+}
+
+// isPlaceholderSecret сообщает, что значение похоже на заглушку: слово из
+// placeholderSecrets, в том числе повторенное (changemechangeme), один и тот
+// же символ (aaaa, 0000), короткий повторяющийся фрагмент (abab) или
+// последовательность (12345678, abcdefgh)
+func isPlaceholderSecret(value string) bool {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(value))
+	if normalized == "" {
+		return true
+	}
+
+	unit := normalized[:repeatPeriod(normalized)]
+	if len(unit) <= 4 || isSequence(unit) {
+		return true
+	}
+	return placeholderSecrets[strings.TrimRight(unit, "0123456789")]
+}
+
+// repeatPeriod возвращает длину наименьшего фрагмента, повторением которого
+// получается s (для неповторяющейся строки - len(s))
+func repeatPeriod(s string) int {
+	for p := 1; p < len(s); p++ {
+		if len(s)%p == 0 && strings.Repeat(s[:p], len(s)/p) == s {
+			return p
+		}
+	}
+	return len(s)
+}
+
+// isSequence сообщает, что s - отрезок ряда цифр или букв по порядку
+// (в прямом или обратном направлении)
+func isSequence(s string) bool {
+	const digits, letters = "01234567890123456789", "abcdefghijklmnopqrstuvwxyz"
+	reversed := []byte(s)
+	slices.Reverse(reversed)
+	for _, seq := range []string{digits, letters} {
+		if strings.Contains(seq, s) || strings.Contains(seq, string(reversed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProduction сообщает, что сервер запущен в production окружении
+func (c Config) isProduction() bool {
+	return c.Env == "production"
+}
+
+// redactedValue - значение секретного поля в выводе конфигурации
+const redactedValue = "***"
+
+// redacted возвращает конфигурацию для вывода: поля с тегом secret:"true"
+// заменяются на "***", длительности выводятся строкой (например, 30s).
+// Пустой секрет остается пустым, чтобы было видно, что он не задан.
+func (c Config) redacted() map[string]interface{} {
+	result := make(map[string]interface{})
+	v := reflect.ValueOf(c)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i).Interface()
+		switch {
+		case field.Tag.Get("secret") == "true":
+			if !v.Field(i).IsZero() {
+				value = redactedValue
+			}
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			value = value.(time.Duration).String()
+		}
+		result[field.Name] = value
+	}
+	return result
+}
+
+// allowedDBParams - параметры драйвера SQLite, которые можно задать через
+// DB_PARAMS. Они влияют только на производительность и блокировки;
+// параметры, отключающие проверки или меняющие доступ (_foreign_keys,
+// _ignore_check_constraints, _writable_schema, _auth*, mode, vfs), не допускаются.
+var allowedDBParams = map[string]bool{
+	"_cache_size":    true,
+	"_busy_timeout":  true,
+	"_journal_mode":  true,
+	"_synchronous":   true,
+	"_txlock":        true,
+	"_auto_vacuum":   true,
+	"_secure_delete": true,
+	"_loc":           true,
+	"cache":          true,
+}
+
+// dsnSecretParams - параметры строки подключения, скрываемые в логе
+var dsnSecretParams = map[string]bool{"_auth_user": true, "_auth_pass": true, "_auth_salt": true}
+
+// dbParams разбирает DB_PARAMS и проверяет ключи по allowedDBParams
+func (c Config) dbParams() (url.Values, error) {
+	params, err := url.ParseQuery(c.DBParams)
+	if err != nil {
+		return nil, fmt.Errorf("DB_PARAMS: %v", err)
+	}
+	for key, values := range params {
+		if !allowedDBParams[key] {
+			return nil, fmt.Errorf("DB_PARAMS: parameter %q is not allowed", key)
+		}
+		if len(values) != 1 || values[0] == "" {
+			return nil, fmt.Errorf("DB_PARAMS: parameter %q must have exactly one value", key)
+		}
+	}
+	return params, nil
+}
+
+// withDBParams добавляет к параметрам подключения параметры из DB_PARAMS.
+// Обязательные параметры сервиса не переопределяются.
+func (c Config) withDBParams(path string, params url.Values) string {
+	extra, _ := c.dbParams()
+	for key, values := range extra {
+		if _, ok := params[key]; !ok {
+			params[key] = values
+		}
+	}
+	return path + "?" + params.Encode()
+}
+
+// dbDSN возвращает строку подключения к основной базе
+func (c Config) dbDSN() string {
+	return c.withDBParams(dbFile, url.Values{"_foreign_keys": {"on"}})
+}
+
+// readDSN возвращает строку подключения к реплике или пустую строку.
+// READ_DSN используется как есть, READ_DB_PATH открывается только на чтение.
+func (c Config) readDSN() string {
+	if c.ReadDSN != "" {
+		return c.ReadDSN
+	}
+	if c.ReadDBPath != "" {
+		return c.withDBParams("file:"+c.ReadDBPath, url.Values{"mode": {"ro"}})
+	}
+	return ""
+}
+
+// tenantPath возвращает файл базы арендатора
+func (c Config) tenantPath(id string) string {
+	return filepath.Join(c.TenantDir, id+".db")
+}
+
+// tenantDSN возвращает строку подключения к базе арендатора
+func (c Config) tenantDSN(id string) string {
+	return c.withDBParams(c.tenantPath(id), url.Values{"_foreign_keys": {"on"}})
+}
+
+// redactDSN скрывает учетные данные в строке подключения для вывода в лог
+func redactDSN(dsn string) string {
+	path, query, found := strings.Cut(dsn, "?")
+	if !found {
+		return dsn
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return path + "?" + redactedValue
+	}
+	for key := range params {
+		if dsnSecretParams[key] {
+			params.Set(key, redactedValue)
+		}
+	}
+	return path + "?" + params.Encode()
+}
+
+// getEnv возвращает значение переменной окружения или значение по умолчанию
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvList читает список значений через запятую, пропуская пустые элементы
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvBool читает булеву переменную окружения, возвращая значение по умолчанию
+// если переменная не задана или не распознана
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvInt читает целочисленную переменную окружения, возвращая значение по умолчанию
+// если переменная не задана или не распознана
+func getEnvInt(key string, defaultValue int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q, using %d", key, raw, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDuration читает длительность (например, 10ms или 30s) из переменной окружения,
+// возвращая значение по умолчанию если переменная не задана или не распознана
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q, using %v", key, raw, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvFloat читает дробное число из переменной окружения, возвращая значение
+// по умолчанию если переменная не задана или не распознана
+func getEnvFloat(key string, defaultValue float64) float64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q, using %v", key, raw, defaultValue)
+		return defaultValue
+	}
+	return value
+}
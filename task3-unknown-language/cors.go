@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// methodOrder - порядок методов в заголовке Access-Control-Allow-Methods
+var methodOrder = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// routeMethods связывает шаблон пути маршрута с его HTTP-методами
+type routeMethods struct {
+	path    *regexp.Regexp
+	methods []string
+}
+
+// corsHandler оборачивает роутер и добавляет CORS заголовки. Разрешенные
+// методы берутся из маршрутов, зарегистрированных для пути запроса, поэтому
+// заголовок не расходится с роутером. Обертка снаружи роутера нужна, чтобы
+// preflight OPTIONS не получал 405 от роутера. Методы из DISABLED_METHODS
+// исключаются из заголовков и отклоняются с 405 до маршрутизации.
+// Запросы передаются в next: это роутер или выбор базы арендатора перед ним.
+func (s *Server) corsHandler(router *mux.Router, next http.Handler) http.Handler {
+	routes := collectRouteMethods(router)
+	allowedHeaders := "Content-Type, Content-Encoding, Authorization"
+	if s.config.TenantDir != "" {
+		allowedHeaders += ", " + s.config.TenantHeader
+	}
+	disabled := s.disabledMethods()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := allowedMethods(routes, disabled, r.URL.Path)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", allowed)
+		w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+
+		// Обработка preflight OPTIONS запросов
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Отключенный в этом развертывании метод не доходит до обработчиков
+		if disabled[r.Method] {
+			w.Header().Set("Allow", allowed)
+			s.writeError(w, r, apiError{
+				Status:  http.StatusMethodNotAllowed,
+				Code:    "method_disabled",
+				Message: "Method is disabled on this server",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// disabledMethods возвращает методы из DISABLED_METHODS как множество
+func (s *Server) disabledMethods() map[string]bool {
+	disabled := make(map[string]bool)
+	for _, method := range s.config.DisabledMethods {
+		disabled[method] = true
+	}
+	return disabled
+}
+
+// collectRouteMethods читает шаблоны путей и методы зарегистрированных маршрутов
+func collectRouteMethods(router *mux.Router) []routeMethods {
+	var routes []routeMethods
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		pattern, err := route.GetPathRegexp()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		routes = append(routes, routeMethods{path: regexp.MustCompile(pattern), methods: methods})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to collect route methods: %v", err)
+	}
+	return routes
+}
+
+// allowedMethods возвращает методы, которые роутер принимает для пути, кроме
+// отключенных, и OPTIONS
+func allowedMethods(routes []routeMethods, disabled map[string]bool, path string) string {
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if !route.path.MatchString(path) {
+			continue
+		}
+		for _, method := range route.methods {
+			seen[method] = true
+		}
+	}
+
+	var methods []string
+	for _, method := range methodOrder {
+		if seen[method] && !disabled[method] {
+			methods = append(methods, method)
+		}
+	}
+	return strings.Join(append(methods, "OPTIONS"), ", ")
+}
+
+// isRoutedMethod проверяет, что метод есть среди методов маршрутов
+func isRoutedMethod(method string) bool {
+	for _, m := range methodOrder {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Режимы подсчета для параметра count в GET /users
+const (
+	countExact     = "exact"
+	countEstimated = "estimated"
+	countNone      = "none"
+)
+
+// parseCountMode читает режим подсчета; по умолчанию используется точный
+func parseCountMode(r *http.Request) (string, error) {
+	switch mode := r.URL.Query().Get("count"); mode {
+	case "":
+		return countExact, nil
+	case countExact, countEstimated, countNone:
+		return mode, nil
+	default:
+		return "", badRequest("Invalid count: use exact, estimated or none")
+	}
+}
+
+// countUsers возвращает количество пользователей и режим, который его дал.
+// Оценка доступна только для всей таблицы, поэтому с фильтрами
+// режим estimated выполняет точный подсчет.
+func (st *store) countUsers(ctx context.Context, mode string, filter userFilter) (int64, string, error) {
+	if mode == countEstimated && filter.empty() {
+		count, err := st.estimateUsers(ctx)
+		return count, countEstimated, err
+	}
+
+	var count int64
+	err := st.readDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+filter.where(), filter.args...).Scan(&count)
+	return count, countExact, err
+}
+
+// estimateUsers быстро оценивает размер таблицы: по статистике ANALYZE
+// из sqlite_stat1, а без нее - по максимальному rowid
+func (st *store) estimateUsers(ctx context.Context) (int64, error) {
+	var stat string
+	err := st.readDB.QueryRowContext(ctx,
+		"SELECT stat FROM sqlite_stat1 WHERE tbl = 'users' LIMIT 1").Scan(&stat)
+	if err == nil {
+		// Первое число stat - количество строк в таблице; пустая или
+		// испорченная статистика заменяется оценкой по rowid
+		if fields := strings.Fields(stat); len(fields) > 0 {
+			if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				return n, nil
+			}
+		}
+	}
+
+	var maxID int64
+	err = st.readDB.QueryRowContext(ctx, "SELECT COALESCE(MAX(rowid), 0) FROM users").Scan(&maxID)
+	return maxID, err
+}
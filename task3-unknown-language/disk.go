@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"path/filepath"
+)
+
+// dbFile - файл базы данных SQLite
+const dbFile = "./users.db"
+
+// errDiskStatsUnsupported - свободное место нельзя узнать на этой платформе
+var errDiskStatsUnsupported = errors.New("disk stats are not supported on this platform")
+
+// freeDiskBytes возвращает свободное место на томе с dir. Переменная, а не
+// функция, чтобы тесты подставляли результат statfs.
+var freeDiskBytes = statfsFreeBytes
+
+// DiskHealth - свободное место на томе с файлом базы данных
+type DiskHealth struct {
+	Status       string  `json:"status"`
+	FreeBytes    *uint64 `json:"free_bytes,omitempty"`
+	MinFreeBytes int64   `json:"min_free_bytes"`
+}
+
+// checkDisk проверяет свободное место на томе с базой. Статус low означает,
+// что места меньше DISK_MIN_FREE_BYTES и запись скоро начнет падать;
+// unknown - место узнать не удалось, это не считается деградацией.
+func (s *Server) checkDisk() DiskHealth {
+	health := DiskHealth{Status: "ok", MinFreeBytes: s.config.DiskMinFreeBytes}
+
+	free, err := freeDiskBytes(filepath.Dir(dbFile))
+	if err != nil {
+		if err != errDiskStatsUnsupported {
+			log.Printf("Failed to check free disk space: %v", err)
+		}
+		health.Status = "unknown"
+		return health
+	}
+	health.FreeBytes = &free
+	if free < uint64(s.config.DiskMinFreeBytes) {
+		health.Status = "low"
+	}
+	return health
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+// statfsFreeBytes на платформах без statfs всегда сообщает, что место неизвестно
+func statfsFreeBytes(dir string) (uint64, error) {
+	return 0, errDiskStatsUnsupported
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// EmailChange - запись журнала изменений email пользователя
+type EmailChange struct {
+	OldEmail  string    `json:"old_email"`
+	NewEmail  string    `json:"new_email"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// createEmailChangesTable создает журнал изменений email если его нет
+func createEmailChangesTable(tx *sql.Tx) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS email_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		old_email TEXT NOT NULL,
+		new_email TEXT NOT NULL,
+		changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_changes_user_id ON email_changes (user_id);`
+
+	_, err := tx.Exec(query)
+	return err
+}
+
+// updateUserInTx обновляет пользователя в транзакции tx и возвращает запись
+// до изменения. Смена email записывается в email_changes в той же транзакции,
+// изменение полей из IMMUTABLE_FIELDS отклоняется с 422.
+// Для несуществующего пользователя возвращается sql.ErrNoRows.
+func (s *Server) updateUserInTx(ctx context.Context, tx *sql.Tx, userID int, userReq UserRequest) (User, error) {
+	previous, err := scanUser(tx.StmtContext(ctx, s.store.stmtGetUser).QueryRowContext(ctx, userID))
+	if err != nil {
+		return User{}, err
+	}
+	if changed := s.changedImmutableFields(previous, userReq); len(changed) > 0 {
+		return User{}, immutableFieldChanged(changed)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET name = ?, email = ?, username = NULLIF(?, ''), age = ? WHERE id = ? AND "+notDeleted,
+		userReq.Name, userReq.Email, userReq.Username, userReq.Age, userID,
+	)
+	if err != nil {
+		return User{}, err
+	}
+
+	if previous.Email != userReq.Email {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO email_changes (user_id, old_email, new_email) VALUES (?, ?, ?)",
+			userID, previous.Email, userReq.Email,
+		)
+		if err != nil {
+			return User{}, err
+		}
+	}
+	return previous, nil
+}
+
+// emailHistoryHandler - журнал изменений email пользователя по порядку
+func (s *Server) emailHistoryHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	exists, err := userExists(r.Context(), s.store.readDB, userID)
+	if err != nil {
+		return dbError(err, "Failed to fetch email history")
+	}
+	if !exists {
+		return notFound("User not found")
+	}
+
+	rows, err := s.store.readDB.QueryContext(r.Context(),
+		"SELECT old_email, new_email, changed_at FROM email_changes WHERE user_id = ? ORDER BY changed_at, id LIMIT ?",
+		userID, s.config.AbsoluteMaxResults+1,
+	)
+	if err != nil {
+		return dbError(err, "Failed to fetch email history")
+	}
+	defer rows.Close()
+
+	// Лишняя запись показывает, что потолок ABSOLUTE_MAX_RESULTS применен
+	changes := make([]EmailChange, 0)
+	capped := false
+	for rows.Next() {
+		if len(changes) == s.config.AbsoluteMaxResults {
+			capped = true
+			break
+		}
+		var change EmailChange
+		if err := rows.Scan(&change.OldEmail, &change.NewEmail, &change.ChangedAt); err != nil {
+			return dbError(err, "Failed to fetch email history")
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return dbError(err, "Failed to fetch email history")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"changes": changes,
+		"capped":  capped,
+	})
+	return nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// apiError описывает ошибку, которую обработчик возвращает клиенту
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Details []string
+
+	// messages - детали с кодами, переводимые при отображении
+	messages []message
+
+	// dbFailure отмечает ошибку базы, которую учитывает предохранитель
+	dbFailure bool
+
+	// cause - исходная ошибка блокировки базы, чтобы withRetry повторил
+	// транзакцию, даже если ошибка операции уже преобразована в apiError
+	cause error
+}
+
+// Error реализует интерфейс error
+func (e apiError) Error() string {
+	return e.Message
+}
+
+// Unwrap возвращает исходную ошибку базы, если она сохранена
+func (e apiError) Unwrap() error {
+	return e.cause
+}
+
+// badRequest - ошибка некорректного запроса (400)
+func badRequest(message string) apiError {
+	return apiError{Status: http.StatusBadRequest, Code: "bad_request", Message: message}
+}
+
+// validationFailed - ошибка валидации с подробностями (400)
+func validationFailed(details []message) apiError {
+	return apiError{
+		Status:   http.StatusBadRequest,
+		Code:     "validation_failed",
+		Message:  "Validation failed",
+		messages: details,
+	}
+}
+
+// invalidEncoding - тело содержит строки не в кодировке UTF-8 (422)
+func invalidEncoding(details []message) apiError {
+	return apiError{
+		Status:   http.StatusUnprocessableEntity,
+		Code:     "invalid_utf8",
+		Message:  "Request fields must be valid UTF-8",
+		messages: details,
+	}
+}
+
+// invalidNumber - число не подходит по типу поля, например дробный возраст (422)
+func invalidNumber(details []message) apiError {
+	return apiError{
+		Status:   http.StatusUnprocessableEntity,
+		Code:     "invalid_number",
+		Message:  "Numeric fields must be whole numbers",
+		messages: details,
+	}
+}
+
+// immutableFieldChanged - попытка изменить неизменяемое поле (422)
+func immutableFieldChanged(details []message) apiError {
+	return apiError{
+		Status:   http.StatusUnprocessableEntity,
+		Code:     "immutable_field",
+		Message:  "Immutable fields cannot be changed",
+		messages: details,
+	}
+}
+
+// unauthorized - ошибка авторизации (401)
+func unauthorized(message string) apiError {
+	return apiError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: message}
+}
+
+// notFound - ресурс не найден (404)
+func notFound(message string) apiError {
+	return apiError{Status: http.StatusNotFound, Code: "not_found", Message: message}
+}
+
+// conflict - конфликт с существующими данными (409)
+func conflict(message string) apiError {
+	return apiError{Status: http.StatusConflict, Code: "conflict", Message: message}
+}
+
+// serviceUnavailable - сервис временно недоступен (503). Retry-After
+// добавляется при записи ответа в writeError.
+func serviceUnavailable(code, message string) apiError {
+	return apiError{Status: http.StatusServiceUnavailable, Code: code, Message: message}
+}
+
+// statusClientClosedRequest - нестандартный статус 499 (nginx): клиент
+// закрыл соединение до ответа
+const statusClientClosedRequest = 499
+
+// requestCanceled - клиент отключился, и контекст запроса отменен (499)
+func requestCanceled() apiError {
+	return apiError{Status: statusClientClosedRequest, Code: "client_closed_request", Message: "Client closed request"}
+}
+
+// requestTimeout - запрос к базе прерван дедлайном бюджета времени (504)
+func requestTimeout() apiError {
+	return apiError{Status: http.StatusGatewayTimeout, Code: "request_timeout", Message: "Request timed out"}
+}
+
+// internalError - внутренняя ошибка сервера (500)
+func internalError(message string) apiError {
+	return apiError{Status: http.StatusInternalServerError, Code: "internal_error", Message: message}
+}
+
+// dbError преобразует ошибку базы данных в apiError. Отсутствующая таблица
+// или поврежденный файл базы дают 503, чтобы отличать инциденты с данными
+// от обычных ошибок; блокировка после исчерпания повторов тоже дает 503.
+// Истекший дедлайн запроса дает 504, отключение клиента - 499.
+// Остальные ошибки становятся 500 с сообщением message; apiError,
+// возвращенный из транзакции, передается как есть. Каждая ошибка базы,
+// кроме отключения клиента, отмечается dbFailure и учитывается
+// предохранителем при отображении ответа.
+func dbError(err error, message string) error {
+	var apiErr apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if errors.Is(err, context.Canceled) {
+		return requestCanceled()
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		apiErr = requestTimeout()
+	case isDatabaseUnavailable(err):
+		log.Printf("Database unavailable: %v", err)
+		apiErr = serviceUnavailable("database_unavailable",
+			"Database is unavailable: the users table is missing or the database file is corrupted")
+	case isBusyError(err):
+		apiErr = serviceUnavailable("database_busy", "Database is busy, please retry")
+		apiErr.cause = err
+	default:
+		apiErr = internalError(message)
+	}
+	apiErr.dbFailure = true
+	return apiErr
+}
+
+// isDatabaseUnavailable распознает отсутствие таблицы и повреждение базы
+func isDatabaseUnavailable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrCorrupt, sqlite3.ErrNotADB:
+			return true
+		}
+	}
+	return strings.Contains(err.Error(), "no such table")
+}
+
+// apiHandler оборачивает обработчик, возвращающий ошибку вместо записи
+// ответа об ошибке: ошибка отображается, а ошибка базы учитывается
+// предохранителем
+func (s *Server) apiHandler(h func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			var apiErr apiError
+			if errors.As(err, &apiErr) && apiErr.dbFailure {
+				s.breaker.failure()
+			}
+			s.writeError(w, r, err)
+		}
+	})
+}
+
+// jsonContentType - Content-Type всех JSON-ответов
+const jsonContentType = "application/json; charset=utf-8"
+
+// writeJSON записывает ответ в формате JSON с указанным статусом
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// routeNotFoundHandler заменяет текстовый ответ роутера для неизвестного пути
+func (s *Server) routeNotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeError(w, r, notFound("Not found"))
+}
+
+// methodNotAllowedHandler заменяет текстовый ответ роутера для
+// неподдерживаемого метода. Allow содержит методы пути, как и ответ 405
+// для DISABLED_METHODS в corsHandler.
+func (s *Server) methodNotAllowedHandler(routes []routeMethods) http.Handler {
+	disabled := s.disabledMethods()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allowedMethods(routes, disabled, r.URL.Path))
+		s.writeError(w, r, apiError{
+			Status:  http.StatusMethodNotAllowed,
+			Code:    "method_not_allowed",
+			Message: "Method not allowed",
+		})
+	})
+}
+
+// writeError записывает ошибку в формате ErrorResponse на языке клиента.
+// Отмена и дедлайн контекста, не прошедшие через dbError, отображаются так
+// же, как в dbError; прочие ошибки, не являющиеся apiError, логируются и
+// скрываются за 500.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr apiError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.Is(err, context.Canceled):
+		apiErr = requestCanceled()
+	case errors.Is(err, context.DeadlineExceeded):
+		apiErr = requestTimeout()
+	default:
+		log.Printf("Unhandled error: %v", err)
+		apiErr = internalError("Internal server error")
+	}
+
+	// Отключившемуся клиенту тело не нужно: статус 499 остается только
+	// для логов и метрик, ошибка не логируется как сбой сервера
+	if apiErr.Status == statusClientClosedRequest {
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+
+	lang := requestLang(r)
+	details := apiErr.Details
+	for _, m := range apiErr.messages {
+		details = append(details, translate(lang, m))
+	}
+
+	// Любой 503 сообщает клиенту, через сколько повторить запрос
+	if apiErr.Status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+		w.Header().Set("Retry-After", retryAfterSeconds(s.config.RetryAfter))
+	}
+
+	response := ErrorResponse{
+		Error:   translateError(lang, apiErr.Message),
+		Code:    apiErr.Code,
+		Details: details,
+	}
+
+	// Количество деталей ограничено, чтобы ответ оставался небольшим
+	if len(details) > s.config.MaxErrorDetails {
+		response.Details = details[:s.config.MaxErrorDetails]
+		response.Truncated = true
+		response.TotalErrors = len(details)
+	}
+
+	// Ошибки не кешируются, даже если маршрут разрешает кеширование
+	w.Header().Set("Cache-Control", defaultCacheControl)
+	w.Header().Set("Content-Language", lang)
+	if s.wantsProblemJSON(r) {
+		writeProblem(w, r, apiErr.Status, response)
+		return
+	}
+	writeJSON(w, apiErr.Status, response)
+}
+
+// retryAfterSeconds форматирует задержку для Retry-After в целых секундах,
+// округляя вверх
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// problemContentType - Content-Type ошибок в формате RFC 7807
+const problemContentType = "application/problem+json; charset=utf-8"
+
+// ProblemDetails - ошибка в формате RFC 7807. Code, Errors, Truncated и
+// TotalErrors - расширения с полями ErrorResponse.
+type ProblemDetails struct {
+	Type        string   `json:"type"`
+	Title       string   `json:"title"`
+	Status      int      `json:"status"`
+	Detail      string   `json:"detail"`
+	Instance    string   `json:"instance"`
+	Code        string   `json:"code,omitempty"`
+	Errors      []string `json:"errors,omitempty"`
+	Truncated   bool     `json:"truncated,omitempty"`
+	TotalErrors int      `json:"total_errors,omitempty"`
+}
+
+// wantsProblemJSON сообщает, что ошибку нужно отдать в формате RFC 7807:
+// клиент запросил application/problem+json или включен PROBLEM_JSON
+func (s *Server) wantsProblemJSON(r *http.Request) bool {
+	return s.config.ProblemJSON || strings.Contains(r.Header.Get("Accept"), "application/problem+json")
+}
+
+// writeProblem записывает ошибку в формате RFC 7807
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, resp ErrorResponse) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ProblemDetails{
+		Type:        "about:blank",
+		Title:       http.StatusText(status),
+		Status:      status,
+		Detail:      resp.Error,
+		Instance:    r.URL.Path,
+		Code:        resp.Code,
+		Errors:      resp.Details,
+		Truncated:   resp.Truncated,
+		TotalErrors: resp.TotalErrors,
+	})
+}
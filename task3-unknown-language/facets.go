@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// userFacets - допустимые измерения для ?facets= и их SQL-выражения.
+// Строки, для которых выражение дает NULL, в счетчики не попадают.
+var userFacets = map[string]string{
+	// Строки сортируются в порядке возраста, поэтому границы выбраны
+	// так, чтобы лексикографический порядок совпадал с числовым
+	"age_bracket": `CASE
+		WHEN age < 18 THEN '0-17'
+		WHEN age < 25 THEN '18-24'
+		WHEN age < 35 THEN '25-34'
+		WHEN age < 45 THEN '35-44'
+		WHEN age < 55 THEN '45-54'
+		WHEN age < 65 THEN '55-64'
+		ELSE '65+' END`,
+	// Email без '@' или с пустым доменом не учитываются, как в /stats/domains
+	"domain": "CASE WHEN instr(email, '@') > 0 AND " + emailDomainExpr + " <> '' THEN " + emailDomainExpr + " END",
+}
+
+// parseFacets читает список измерений из ?facets=age_bracket,domain
+func parseFacets(r *http.Request) ([]string, error) {
+	value := r.URL.Query().Get("facets")
+	if value == "" {
+		return nil, nil
+	}
+
+	var facets []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := userFacets[name]; !ok {
+			return nil, badRequest(fmt.Sprintf("Invalid facet %q: use age_bracket or domain", name))
+		}
+		if !seen[name] {
+			seen[name] = true
+			facets = append(facets, name)
+		}
+	}
+	return facets, nil
+}
+
+// countFacets считает пользователей по каждому измерению на всем
+// отфильтрованном наборе. У измерения остается не больше maxBuckets самых
+// частых значений (доменов может быть сколько угодно); capped - измерения,
+// значения которых не поместились.
+func (st *store) countFacets(ctx context.Context, facets []string, filter userFilter, maxBuckets int) (result map[string]map[string]int64, capped []string, err error) {
+	result = make(map[string]map[string]int64, len(facets))
+	err = st.withReadOnlyTx(ctx, func(tx *sql.Tx) error {
+		for _, name := range facets {
+			rows, err := tx.QueryContext(ctx,
+				"SELECT "+userFacets[name]+" AS value, COUNT(*) FROM users"+filter.where()+
+					" GROUP BY value HAVING value IS NOT NULL ORDER BY COUNT(*) DESC, value LIMIT ?",
+				append(append([]interface{}{}, filter.args...), maxBuckets+1)...,
+			)
+			if err != nil {
+				return err
+			}
+
+			counts := make(map[string]int64)
+			for rows.Next() {
+				var value sql.NullString
+				var count int64
+				if err := rows.Scan(&value, &count); err != nil {
+					rows.Close()
+					return err
+				}
+				if len(counts) == maxBuckets {
+					capped = append(capped, name)
+					break
+				}
+				counts[value.String] = count
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			result[name] = counts
+		}
+		return nil
+	})
+	return result, capped, err
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqliteTimeFormat - формат, в котором SQLite хранит CURRENT_TIMESTAMP
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// userFilter накапливает условия WHERE для выборки пользователей
+type userFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+// add добавляет условие с параметрами
+func (f *userFilter) add(condition string, args ...interface{}) {
+	f.conditions = append(f.conditions, condition)
+	f.args = append(f.args, args...)
+}
+
+// empty сообщает, что фильтр не содержит условий
+func (f *userFilter) empty() bool {
+	return len(f.conditions) == 0
+}
+
+// where возвращает SQL-фрагмент WHERE. Мягко удаленные пользователи
+// исключаются всегда, даже без условий фильтра.
+func (f *userFilter) where() string {
+	return " WHERE " + strings.Join(append([]string{notDeleted}, f.conditions...), " AND ")
+}
+
+// sqlDebug описывает выполненный запрос для отладки фильтров: текст SQL
+// с плейсхолдерами и отдельно параметры, без подстановки значений в SQL
+func sqlDebug(query string, args []interface{}) map[string]interface{} {
+	if args == nil {
+		args = []interface{}{}
+	}
+	return map[string]interface{}{
+		"sql":  query,
+		"args": args,
+	}
+}
+
+// filterUsersParams - параметры фильтра, общие для GET /users и /users/stream
+var filterUsersParams = []string{"name", "email", "match", "created_within", "created_after", "created_before", "label"}
+
+// listUsersParams - параметры запроса, известные GET /users
+var listUsersParams = append([]string{"sort", "count", "facets", "limit", "offset"}, filterUsersParams...)
+
+// parseUserFilter строит фильтр списка пользователей из параметров запроса
+func (s *Server) parseUserFilter(r *http.Request) (userFilter, error) {
+	var filter userFilter
+	query := r.URL.Query()
+
+	// Поиск по подстроке в имени и email; ?match=any объединяет условия
+	// поиска через OR, по умолчанию (all) - через AND. Остальные фильтры
+	// всегда объединяются через AND.
+	var search userFilter
+	if value := query.Get("name"); value != "" {
+		// LIKE в SQLite не учитывает регистр латиницы и не зависит от
+		// COLLATE, поэтому при NAME_COLLATION=binary используется instr
+		if s.nameCollation() == "BINARY" {
+			search.add("instr(name, ?) > 0", value)
+		} else {
+			search.add(`name LIKE ? ESCAPE '\'`, containsPattern(value))
+		}
+	}
+	if value := query.Get("email"); value != "" {
+		search.add(`email LIKE ? ESCAPE '\'`, containsPattern(value))
+	}
+	switch query.Get("match") {
+	case "", "all":
+		filter.conditions = append(filter.conditions, search.conditions...)
+		filter.args = append(filter.args, search.args...)
+	case "any":
+		if !search.empty() {
+			filter.add("("+strings.Join(search.conditions, " OR ")+")", search.args...)
+		}
+	default:
+		return filter, badRequest("Invalid match: use any or all")
+	}
+
+	// Пользователи, созданные за указанный период до текущего момента
+	if value := query.Get("created_within"); value != "" {
+		since, err := parseCreatedWithin(value, time.Now())
+		if err != nil {
+			return filter, badRequest("Invalid created_within: use a duration like 24h or an ISO 8601 duration like P7D")
+		}
+		filter.add("created_at >= ?", since.UTC().Format(sqliteTimeFormat))
+	}
+
+	// Границы периода создания: created_after включительно, created_before исключительно
+	if value := query.Get("created_after"); value != "" {
+		after, err := parseTimestampParam("created_after", value)
+		if err != nil {
+			return filter, err
+		}
+		filter.add("created_at >= ?", after.UTC().Format(sqliteTimeFormat))
+	}
+	if value := query.Get("created_before"); value != "" {
+		before, err := parseTimestampParam("created_before", value)
+		if err != nil {
+			return filter, err
+		}
+		filter.add("created_at < ?", before.UTC().Format(sqliteTimeFormat))
+	}
+
+	// Пользователи с метками key:value; несколько меток объединяются через AND
+	for _, value := range query["label"] {
+		key, labelValue, ok := parseLabelFilter(value)
+		if !ok {
+			return filter, badRequest("Invalid label filter: use label=key:value")
+		}
+		filter.add("id IN (SELECT user_id FROM user_labels WHERE key = ? AND value = ?)", key, labelValue)
+	}
+
+	return filter, nil
+}
+
+// likeEscaper экранирует спецсимволы LIKE, чтобы они искались буквально
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// containsPattern строит шаблон LIKE для поиска подстроки
+func containsPattern(value string) string {
+	return "%" + likeEscaper.Replace(value) + "%"
+}
+
+// localTimestampLayouts - форматы времени без смещения, которые клиенты
+// присылают в локальном времени; распознаются только для подсказки в ошибке
+var localTimestampLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// parseTimestampParam разбирает момент времени в RFC 3339. Смещение
+// обязательно: время без него не считается UTC молча, так как клиенты в
+// других часовых поясах получили бы выборку со сдвигом на несколько часов.
+func parseTimestampParam(name, value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range localTimestampLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return time.Time{}, badRequest(fmt.Sprintf(
+				"Invalid %s: timestamp has no UTC offset; add one, e.g. 2025-09-03T08:30:00Z or 2025-09-03T11:30:00+03:00", name))
+		}
+	}
+	return time.Time{}, badRequest(fmt.Sprintf("Invalid %s: use an RFC 3339 timestamp like 2025-09-03T08:30:00Z", name))
+}
+
+// isoDurationPattern разбирает длительность ISO 8601 (PnYnMnWnDTnHnMnS)
+var isoDurationPattern = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseCreatedWithin возвращает момент времени now минус длительность.
+// Поддерживаются длительности Go (168h) и ISO 8601 (P7D, PT12H, P1M).
+func parseCreatedWithin(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, strconv.ErrRange
+		}
+		return now.Add(-d), nil
+	}
+
+	match := isoDurationPattern.FindStringSubmatch(value)
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return time.Time{}, strconv.ErrSyntax
+	}
+
+	parts := make([]int, len(match)-1)
+	total := 0
+	for i, raw := range match[1:] {
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return time.Time{}, err
+		}
+		parts[i] = n
+		total += n
+	}
+	if total == 0 {
+		return time.Time{}, strconv.ErrRange
+	}
+
+	// Календарные единицы вычитаются через AddDate, чтобы учесть длину месяцев
+	years, months, weeks, days := parts[0], parts[1], parts[2], parts[3]
+	clock := time.Duration(parts[4])*time.Hour + time.Duration(parts[5])*time.Minute + time.Duration(parts[6])*time.Second
+	return now.AddDate(-years, -months, -(weeks*7 + days)).Add(-clock), nil
+}
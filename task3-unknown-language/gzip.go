@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// gzipWriterPools хранит пулы gzip.Writer по уровням сжатия
+var gzipWriterPools sync.Map
+
+// getGzipWriter возвращает gzip.Writer нужного уровня из пула
+func getGzipWriter(level int) *gzip.Writer {
+	pool, _ := gzipWriterPools.LoadOrStore(level, &sync.Pool{
+		New: func() interface{} {
+			gz, _ := gzip.NewWriterLevel(nil, level)
+			return gz
+		},
+	})
+	return pool.(*sync.Pool).Get().(*gzip.Writer)
+}
+
+// putGzipWriter возвращает gzip.Writer в пул
+func putGzipWriter(level int, gz *gzip.Writer) {
+	if pool, ok := gzipWriterPools.Load(level); ok {
+		pool.(*sync.Pool).Put(gz)
+	}
+}
+
+// gzipResponseWriter буферизует начало ответа и включает сжатие только
+// когда размер ответа достигает GZIP_MIN_BYTES
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	level    int
+	minBytes int
+	status   int
+	buf      bytes.Buffer
+	gz       *gzip.Writer
+	decided  bool
+}
+
+// WriteHeader откладывает отправку статуса до выбора режима сжатия
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write накапливает данные до порога, затем пишет их сжатыми
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf.Write(p)
+	if w.buf.Len() >= w.minBytes {
+		if err := w.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush начинает сжатие немедленно, чтобы потоковые ответы не буферизовались
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.startGzip()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// startGzip отправляет заголовки сжатого ответа и сбрасывает буфер
+func (w *gzipResponseWriter) startGzip() error {
+	w.decided = true
+
+	// Уже закодированные ответы не сжимаются повторно, а частичные ответы
+	// отдаются как есть: Content-Range указывает байты несжатого содержимого
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Content-Range") != "" {
+		return w.flushPlain()
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.statusCode())
+
+	w.gz = getGzipWriter(w.level)
+	w.gz.Reset(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// flushPlain отправляет буферизованный ответ без сжатия
+func (w *gzipResponseWriter) flushPlain() error {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.statusCode())
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// close завершает ответ: маленькие ответы отправляются как есть
+func (w *gzipResponseWriter) close() {
+	if !w.decided {
+		w.flushPlain()
+		return
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		putGzipWriter(w.level, w.gz)
+		w.gz = nil
+	}
+}
+
+func (w *gzipResponseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// gzipMiddleware сжимает ответы для клиентов, поддерживающих gzip
+func (s *Server) gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{
+			ResponseWriter: w,
+			level:          s.config.GzipLevel,
+			minBytes:       s.config.GzipMinBytes,
+		}
+		defer gw.close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"golang.org/x/text/language"
+)
+
+// messageFS содержит каталоги сообщений для поддерживаемых языков
+//
+//go:embed messages/*.json
+var messageFS embed.FS
+
+// defaultLang - язык по умолчанию и запасной язык для отсутствующих переводов
+const defaultLang = "en"
+
+// supportedLangs - поддерживаемые языки; первый используется по умолчанию
+var supportedLangs = []language.Tag{language.English, language.Russian}
+
+var langMatcher = language.NewMatcher(supportedLangs)
+
+// catalog - переводы для одного языка. Сообщения валидации задаются кодами,
+// сообщения об ошибках - исходным английским текстом.
+type catalog struct {
+	Validation map[string]string `json:"validation"`
+	Errors     map[string]string `json:"errors"`
+}
+
+// catalogs загружаются из встроенных файлов при старте
+var catalogs = loadCatalogs()
+
+// message - сообщение с кодом и аргументами, переводимое при отображении
+type message struct {
+	Code string
+	Args []interface{}
+}
+
+// newMessage создает сообщение с кодом и аргументами
+func newMessage(code string, args ...interface{}) message {
+	return message{Code: code, Args: args}
+}
+
+// loadCatalogs читает встроенные каталоги сообщений
+func loadCatalogs() map[string]catalog {
+	result := make(map[string]catalog, len(supportedLangs))
+	for _, tag := range supportedLangs {
+		lang := tag.String()
+		data, err := messageFS.ReadFile("messages/" + lang + ".json")
+		if err != nil {
+			log.Fatalf("Failed to load %s messages: %v", lang, err)
+		}
+		var c catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			log.Fatalf("Failed to parse %s messages: %v", lang, err)
+		}
+		result[lang] = c
+	}
+	return result
+}
+
+// requestLang выбирает язык ответа по заголовку Accept-Language
+func requestLang(r *http.Request) string {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return defaultLang
+	}
+	_, index, confidence := langMatcher.Match(tags...)
+	if confidence == language.No {
+		return defaultLang
+	}
+	return supportedLangs[index].String()
+}
+
+// translate возвращает текст сообщения валидации на языке lang
+func translate(lang string, m message) string {
+	format, ok := catalogs[lang].Validation[m.Code]
+	if !ok {
+		format, ok = catalogs[defaultLang].Validation[m.Code]
+	}
+	if !ok {
+		return m.Code
+	}
+	if len(m.Args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, m.Args...)
+}
+
+// translateError возвращает перевод сообщения об ошибке или исходный текст
+func translateError(lang, text string) string {
+	if translated, ok := catalogs[lang].Errors[text]; ok {
+		return translated
+	}
+	return text
+}
@@ -0,0 +1,29 @@
+package main
+
+// updatableUserFields - поля пользователя, которые можно объявить
+// неизменяемыми в IMMUTABLE_FIELDS
+var updatableUserFields = map[string]bool{"name": true, "email": true, "username": true, "age": true}
+
+// changedImmutableFields сравнивает входящие значения с сохраненными и
+// возвращает по сообщению на каждое измененное поле из IMMUTABLE_FIELDS.
+// Передача того же значения изменением не считается.
+func (s *Server) changedImmutableFields(stored User, userReq UserRequest) []message {
+	if len(s.config.ImmutableFields) == 0 {
+		return nil
+	}
+
+	updated := stored
+	updated.Name = userReq.Name
+	updated.Email = userReq.Email
+	updated.Username = userReq.Username
+	updated.Age = userReq.Age
+	changes := changedFields(stored, updated)
+
+	var errors []message
+	for _, field := range s.config.ImmutableFields {
+		if _, ok := changes[field]; ok {
+			errors = append(errors, newMessage("field_immutable", field))
+		}
+	}
+	return errors
+}
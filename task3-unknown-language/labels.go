@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ограничения на метки пользователя
+const (
+	maxLabelsPerUser = 20
+	maxLabelKeyLen   = 64
+	maxLabelValueLen = 256
+)
+
+// labelKeyPattern - допустимые символы ключа метки
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// LabelsRequest для установки меток пользователя
+type LabelsRequest struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// createLabelsTable создает таблицу меток пользователей если её нет.
+// Метки удаляются вместе с пользователем через внешний ключ.
+func createLabelsTable(tx *sql.Tx) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS user_labels (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (user_id, key)
+	);
+	CREATE INDEX IF NOT EXISTS idx_user_labels_key_value ON user_labels (key, value);`
+
+	_, err := tx.Exec(query)
+	return err
+}
+
+// validateLabels проверяет количество меток и длину ключей и значений
+func validateLabels(labels map[string]string) []message {
+	var errors []message
+
+	if len(labels) > maxLabelsPerUser {
+		errors = append(errors, newMessage("labels_too_many", maxLabelsPerUser))
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !labelKeyPattern.MatchString(key) || utf8.RuneCountInString(key) > maxLabelKeyLen {
+			errors = append(errors, newMessage("label_key_invalid", key, maxLabelKeyLen))
+		}
+		if utf8.RuneCountInString(labels[key]) > maxLabelValueLen {
+			errors = append(errors, newMessage("label_value_too_long", key, maxLabelValueLen))
+		}
+	}
+	return errors
+}
+
+// getUserLabels возвращает метки пользователя
+func getUserLabels(ctx context.Context, q queryer, userID int) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT key, value FROM user_labels WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		labels[key] = value
+	}
+	return labels, rows.Err()
+}
+
+// getUserLabelsHandler - получение меток пользователя
+func (s *Server) getUserLabelsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	exists, err := userExists(r.Context(), s.store.readDB, userID)
+	if err != nil {
+		return dbError(err, "Failed to fetch labels")
+	}
+	if !exists {
+		return notFound("User not found")
+	}
+
+	labels, err := getUserLabels(r.Context(), s.store.readDB, userID)
+	if err != nil {
+		return dbError(err, "Failed to fetch labels")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"labels":  labels,
+	})
+	return nil
+}
+
+// setUserLabelsHandler - замена всех меток пользователя на переданные
+func (s *Server) setUserLabelsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var req LabelsRequest
+	if err := s.decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if errors := validateLabels(req.Labels); len(errors) > 0 {
+		return validationFailed(errors)
+	}
+
+	tx, err := s.store.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return dbError(err, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	exists, err := userExists(r.Context(), tx, userID)
+	if err != nil {
+		return dbError(err, "Failed to set labels")
+	}
+	if !exists {
+		return notFound("User not found")
+	}
+
+	if _, err = tx.ExecContext(r.Context(), "DELETE FROM user_labels WHERE user_id = ?", userID); err != nil {
+		return dbError(err, "Failed to set labels")
+	}
+	for key, value := range req.Labels {
+		_, err = tx.ExecContext(r.Context(),
+			"INSERT INTO user_labels (user_id, key, value) VALUES (?, ?, ?)",
+			userID, key, value,
+		)
+		if err != nil {
+			return dbError(err, "Failed to set labels")
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return dbError(err, "Failed to commit transaction")
+	}
+	s.audit(r, auditLabels, userID, []string{"labels"})
+
+	labels := req.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"labels":  labels,
+	})
+	return nil
+}
+
+// parseLabelFilter разбирает значение ?label=key:value
+func parseLabelFilter(value string) (key, labelValue string, ok bool) {
+	key, labelValue, ok = strings.Cut(value, ":")
+	return key, labelValue, ok && key != ""
+}
+
+// userExists проверяет существование пользователя
+func userExists(ctx context.Context, q queryer, userID int) (bool, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND "+notDeleted+")", userID).Scan(&exists)
+	return exists, err
+}
+
+// queryer - общий интерфейс *sql.DB и *sql.Tx для запросов на чтение
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
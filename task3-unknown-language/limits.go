@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// overrideMaxResultsHeader снимает потолок ABSOLUTE_MAX_RESULTS с потоковой
+// выгрузки; учитывается только в запросе администратора
+const overrideMaxResultsHeader = "X-Override-Max-Results"
+
+// capResults ограничивает запрошенное количество записей потолком
+// ABSOLUTE_MAX_RESULTS и сообщает, был ли применен потолок
+func (s *Server) capResults(n int) (int, bool) {
+	if n > s.config.AbsoluteMaxResults {
+		return s.config.AbsoluteMaxResults, true
+	}
+	return n, false
+}
+
+// maxResultsOverridden сообщает, что администратор явно снял потолок
+// заголовком X-Override-Max-Results: true
+func (s *Server) maxResultsOverridden(r *http.Request) bool {
+	return r.Header.Get(overrideMaxResultsHeader) == "true" && s.isAdmin(r)
+}
+
+// defaultPageLimit и maxPageLimit - размер страницы GET /users по умолчанию
+// и наибольший допустимый
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// parsePage читает ?limit= и ?offset= списка пользователей. Отсутствующее
+// или нечисловое значение заменяется значением по умолчанию (50 и 0),
+// а число вне допустимого диапазона отклоняется с 400.
+func parsePage(r *http.Request) (limit, offset int, err error) {
+	limit, offset = defaultPageLimit, 0
+	query := r.URL.Query()
+	if n, parseErr := strconv.Atoi(query.Get("limit")); parseErr == nil {
+		if n < 1 || n > maxPageLimit {
+			return 0, 0, badRequest(fmt.Sprintf("Limit must be between 1 and %d", maxPageLimit))
+		}
+		limit = n
+	}
+	if n, parseErr := strconv.Atoi(query.Get("offset")); parseErr == nil {
+		if n < 0 {
+			return 0, 0, badRequest("Offset must be non-negative")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
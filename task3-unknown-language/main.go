@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // User представляет структуру пользователя
@@ -19,21 +27,34 @@ type User struct {
 	ID        int       `json:"id"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
+	Username  string    `json:"username,omitempty"`
 	Age       int       `json:"age"`
+	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// BatchGetRequest для запроса нескольких пользователей по ID
+type BatchGetRequest struct {
+	IDs []int `json:"ids"`
+}
+
 // UserRequest для входящих запросов (без ID и CreatedAt)
 type UserRequest struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
-	Age   int    `json:"age"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Age      int    `json:"age"`
 }
 
 // ErrorResponse для возврата ошибок
 type ErrorResponse struct {
 	Error   string   `json:"error"`
+	Code    string   `json:"code,omitempty"`
 	Details []string `json:"details,omitempty"`
+
+	// Truncated и TotalErrors заполняются, если деталей больше MAX_ERROR_DETAILS
+	Truncated   bool `json:"truncated,omitempty"`
+	TotalErrors int  `json:"total_errors,omitempty"`
 }
 
 // SuccessResponse для успешных ответов
@@ -42,52 +63,257 @@ type SuccessResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-var db *sql.DB
+// Server - зависимости обработчиков API. Обработчики и middleware -
+// методы Server, поэтому состояние не хранится в глобальных переменных.
+type Server struct {
+	config     Config
+	store      *store
+	auditLog   *auditLogger
+	breaker    *circuitBreaker
+	statsCache *responseCache
+
+	// tenant - арендатор, чью базу обслуживает Server (пусто без TENANT_DIR)
+	tenant string
+
+	// tenants - базы арендаторов; задается только у основного Server
+	tenants *tenantManager
+}
+
+// newServer создает Server поверх открытой базы. Журнал аудита
+// подключается отдельно и может отсутствовать.
+func newServer(cfg Config, st *store) *Server {
+	return &Server{
+		config:     cfg,
+		store:      st,
+		breaker:    newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		statsCache: newResponseCache(cfg.StatsCacheTTL, cfg.StatsCacheMaxEntries),
+	}
+}
+
+// maxRandomUsers - максимальное значение параметра count для /users/random
+const maxRandomUsers = 100
+
+// minUserAge и maxUserAge - допустимый возраст пользователя
+const (
+	minUserAge = 0
+	maxUserAge = 150
+)
+
+// maxBatchGetIDs - максимальное количество ID в запросе /users/batch-get
+const maxBatchGetIDs = 100
 
 func main() {
-	// Инициализация базы данных
-	var err error
-	db, err = sql.Open("sqlite3", "./users.db")
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run запускает сервер и возвращает ошибку вместо завершения процесса,
+// чтобы отложенные закрытия выполнились до выхода: журнал аудита дописывает
+// очередь, база и экспорт спанов закрываются
+func run() error {
+	// Загрузка конфигурации
+	config, err := loadConfig()
 	if err != nil {
-		log.Fatal("Failed to open database:", err)
+		return fmt.Errorf("invalid configuration: %w", err)
 	}
-	defer db.Close()
 
-	// Создание таблицы пользователей
-	err = createTable()
+	// Трассировка запросов; при ней обращения к базе идут через драйвер со спанами
+	driverName := "sqlite3"
+	if config.OTLPEndpoint != "" {
+		shutdownTracing, err := initTracing(context.Background())
+		if err != nil {
+			return err
+		}
+		defer shutdownTracing(context.Background())
+		driverName = tracedDriverName
+		log.Printf("Tracing enabled, exporting spans to %s", config.OTLPEndpoint)
+	}
+
+	// Инициализация базы данных: проверка файла, миграции и подготовка выражений
+	log.Printf("Database DSN: %s", redactDSN(config.dbDSN()))
+	if dsn := config.readDSN(); dsn != "" {
+		log.Printf("Read queries use a separate read database: %s", redactDSN(dsn))
+	}
+	st, err := openStore(driverName, config.dbDSN(), config.readDSN())
 	if err != nil {
-		log.Fatal("Failed to create table:", err)
+		return err
 	}
+	defer st.close()
 
-	// Настройка маршрутов
-	router := mux.NewRouter()
+	server := newServer(config, st)
 
-	// Эндпоинты API
-	router.HandleFunc("/health", healthHandler).Methods("GET")
-	router.HandleFunc("/users", getUsersHandler).Methods("GET")
-	router.HandleFunc("/users", createUserHandler).Methods("POST")
-	router.HandleFunc("/users/{id}", updateUserHandler).Methods("PUT")
-	router.HandleFunc("/users/{id}", deleteUserHandler).Methods("DELETE")
+	// Журнал аудита пишется в отдельный файл; при остановке оставшиеся
+	// записи дописываются после завершения запросов
+	if config.AuditLog != "" {
+		server.auditLog, err = openAuditLog(config.AuditLog, config.AuditLogMaxBytes, config.AuditLogBackups)
+		if err != nil {
+			return err
+		}
+		defer server.auditLog.close()
+		log.Printf("Audit log: %s", config.AuditLog)
+	}
+
+	// Базы арендаторов открываются при первом обращении; метрики и /health
+	// относятся к основной базе
+	if config.TenantDir != "" {
+		if err := os.MkdirAll(config.TenantDir, 0o755); err != nil {
+			return err
+		}
+		server.tenants = newTenantManager(server, driverName)
+		defer server.tenants.close()
+		log.Printf("Tenant databases: %s (header %s, up to %d open)", config.TenantDir, config.TenantHeader, config.TenantMaxOpen)
+	}
 
-	// Middleware для CORS
-	router.Use(corsMiddleware)
+	// Регистрация метрик
+	server.registerMetrics(prometheus.DefaultRegisterer)
 
-	// Middleware для логирования
-	router.Use(loggingMiddleware)
+	if config.ChaosMode {
+		log.Printf("WARNING: chaos mode enabled (latency rate %.2f up to %v, error rate %.2f)",
+			config.ChaosLatencyRate, config.ChaosLatencyMax, config.ChaosErrorRate)
+	}
 
 	fmt.Println("🚀 User API Server starting on :8080")
 	fmt.Println("📍 Endpoints:")
 	fmt.Println("   GET  /health        - Health check")
+	fmt.Println("   GET  /metrics       - Prometheus metrics")
 	fmt.Println("   GET  /users         - Get all users")
 	fmt.Println("   POST /users         - Create user")
+	fmt.Println("   GET  /users/stream  - Stream all users as a JSON array")
+	fmt.Println("   GET  /users/first   - Earliest signups")
+	fmt.Println("   GET  /users/recent  - Latest signups")
+	fmt.Println("   GET  /users/by-username/{username} - Find user by username")
+	fmt.Println("   POST /users/batch-get - Get users by IDs")
+	fmt.Println("   POST /users/validate-batch - Validate users without saving")
+	fmt.Println("   GET  /users/schema  - Validation rules")
+	fmt.Println("   GET  /users/random  - Get random users")
+	fmt.Println("   GET  /users/{id}    - Get user by ID")
 	fmt.Println("   PUT  /users/{id}    - Update user")
 	fmt.Println("   DELETE /users/{id}  - Delete user")
+	fmt.Println("   GET  /users/{id}/labels - Get user labels")
+	fmt.Println("   PUT  /users/{id}/labels - Set user labels")
+	fmt.Println("   POST /batch         - Atomic batch of create/update/delete")
+	fmt.Println("   POST /validate/email - Validate and normalize an email")
+	fmt.Println("   GET  /users/{id}/email-history - Email change log (admin)")
+	fmt.Println("   POST /users/{id}/suspend - Suspend user (admin)")
+	fmt.Println("   POST /users/{id}/activate - Activate user (admin)")
+	fmt.Println("   GET  /stats/signups - Signup counts by period")
+	fmt.Println("   GET  /stats/domains - Most common email domains")
+	fmt.Println("   POST /admin/age-increment - Increment all ages (admin)")
+	fmt.Println("   POST /admin/users/merge - Merge two accounts (admin)")
+	fmt.Println("   POST /admin/dedup   - Remove duplicate emails (admin)")
+	fmt.Println("   GET  /admin/validate-all - Check stored users against rules (admin)")
+	fmt.Println("   GET  /admin/config - Effective configuration, secrets redacted (admin)")
+	fmt.Println("   GET  /admin/snapshot - Download a consistent database copy (admin)")
+	fmt.Println("   GET  /admin/ui/ - Admin web interface (admin)")
+
+	// h2c оборачивает уже собранный обработчик, поэтому цепочка middleware
+	// выполняется одинаково для HTTP/1.1 и HTTP/2 запросов
+	handler := server.routes()
+	if config.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+		log.Println("Protocol: HTTP/2 cleartext (h2c) with HTTP/1.1 fallback")
+	} else {
+		log.Println("Protocol: HTTP/1.1")
+	}
+
+	return server.serve(server.newHTTPServer(":8080", handler))
+}
+
+// routes собирает маршруты и цепочку middleware. CORS оборачивает роутер
+// снаружи и берет разрешенные методы из маршрутов. При TENANT_DIR запросы
+// направляются в роутер базы арендатора.
+func (s *Server) routes() http.Handler {
+	router := s.router()
+	var handler http.Handler = router
+	if s.tenants != nil {
+		handler = s.tenantMiddleware(router)
+	}
+	return s.corsHandler(router, handler)
+}
+
+// router создает роутер API с middleware. У каждого арендатора свой
+// роутер поверх его базы.
+func (s *Server) router() *mux.Router {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(s.routeNotFoundHandler)
+
+	// Эндпоинты API
+	router.HandleFunc("/health", s.healthHandler).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.Handle("/users", s.apiHandler(s.getUsersHandler)).Methods("GET")
+	router.Handle("/users", s.apiHandler(s.createUserHandler)).Methods("POST")
+	router.Handle("/users/stream", s.apiHandler(s.streamUsersHandler)).Methods("GET")
+	router.Handle("/users/first", s.apiHandler(s.firstUsersHandler)).Methods("GET")
+	router.Handle("/users/recent", s.apiHandler(s.recentUsersHandler)).Methods("GET")
+	router.Handle("/users/by-username/{username}", s.apiHandler(s.getUserByUsernameHandler)).Methods("GET")
+	router.Handle("/users/batch-get", s.apiHandler(s.batchGetUsersHandler)).Methods("POST")
+	router.Handle("/users/validate-batch", s.apiHandler(s.validateBatchHandler)).Methods("POST")
+	router.HandleFunc("/users/schema", s.userSchemaHandler).Methods("GET")
+	router.Handle("/users/random", s.adminMiddleware(s.apiHandler(s.getRandomUsersHandler))).Methods("GET")
+	router.Handle("/users/{id}", s.apiHandler(s.getUserHandler)).Methods("GET")
+	router.Handle("/users/{id}", s.apiHandler(s.updateUserHandler)).Methods("PUT")
+	router.Handle("/users/{id}", s.apiHandler(s.deleteUserHandler)).Methods("DELETE")
+	router.Handle("/users/{id}/labels", s.apiHandler(s.getUserLabelsHandler)).Methods("GET")
+	router.Handle("/users/{id}/labels", s.apiHandler(s.setUserLabelsHandler)).Methods("PUT")
+	router.Handle("/users/{id}/email-history", s.adminMiddleware(s.apiHandler(s.emailHistoryHandler))).Methods("GET")
+	router.Handle("/users/{id}/suspend", s.adminMiddleware(s.apiHandler(s.suspendUserHandler))).Methods("POST")
+	router.Handle("/users/{id}/activate", s.adminMiddleware(s.apiHandler(s.activateUserHandler))).Methods("POST")
+
+	// Несколько операций записи в одной транзакции
+	router.Handle("/batch", s.apiHandler(s.batchHandler)).Methods("POST")
+	router.Handle("/validate/email", s.apiHandler(s.validateEmailHandler)).Methods("POST")
+
+	// Статистика
+	router.Handle("/stats/signups", s.statsCache.middleware(s.apiHandler(s.signupStatsHandler))).Methods("GET")
+	router.Handle("/stats/domains", s.statsCache.middleware(s.apiHandler(s.domainStatsHandler))).Methods("GET")
+
+	// Административные эндпоинты
+	router.Handle("/admin/age-increment", s.adminMiddleware(s.apiHandler(s.ageIncrementHandler))).Methods("POST")
+	router.Handle("/admin/users/merge", s.adminMiddleware(s.apiHandler(s.mergeUsersHandler))).Methods("POST")
+	router.Handle("/admin/dedup", s.adminMiddleware(s.apiHandler(s.dedupHandler))).Methods("POST")
+	router.Handle("/admin/validate-all", s.adminMiddleware(s.apiHandler(s.validateAllHandler))).Methods("GET")
+	router.Handle("/admin/config", s.adminMiddleware(s.apiHandler(s.configHandler))).Methods("GET")
+	router.Handle("/admin/snapshot", s.adminMiddleware(s.apiHandler(s.snapshotHandler))).Methods("GET")
+	router.Handle("/admin/ui", http.RedirectHandler("/admin/ui/", http.StatusMovedPermanently)).Methods("GET")
+	router.PathPrefix("/admin/ui/").Handler(s.adminUIHandler()).Methods("GET")
+	router.MethodNotAllowedHandler = s.methodNotAllowedHandler(collectRouteMethods(router))
+
+	// Спан на каждый запрос
+	if s.config.OTLPEndpoint != "" {
+		router.Use(tracingMiddleware)
+	}
+
+	// Middleware для логирования
+	router.Use(loggingMiddleware)
+
+	// Middleware для сжатия ответов
+	router.Use(s.gzipMiddleware)
+
+	// Cache-Control по шаблону маршрута
+	cacheRules, _ := s.config.cacheControlRules()
+	router.Use(cacheControlMiddleware(cacheRules))
 
-	log.Fatal(http.ListenAndServe(":8080", router))
+	// Предохранитель базы данных
+	if s.config.BreakerThreshold > 0 {
+		router.Use(s.breakerMiddleware)
+	}
+
+	// Бюджет времени обработки запроса
+	if s.config.RequestBudget > 0 {
+		router.Use(s.budgetMiddleware)
+	}
+
+	// Внедрение сбоев для проверки устойчивости клиентов
+	if s.config.ChaosMode {
+		router.Use(s.chaosMiddleware)
+	}
+
+	return router
 }
 
 // createTable создает таблицу пользователей если её нет
-func createTable() error {
+func createTable(tx *sql.Tx) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS users (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -97,48 +323,103 @@ func createTable() error {
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
-	_, err := db.Exec(query)
+	_, err := tx.Exec(query)
 	return err
 }
 
-// validateUser валидирует данные пользователя
-func validateUser(user UserRequest) []string {
-	var errors []string
+// validateUser валидирует данные пользователя и возвращает коды ошибок,
+// которые переводятся на язык клиента при формировании ответа
+func (s *Server) validateUser(user UserRequest) []message {
+	var errors []message
 
 	// Валидация имени
+	// Длина считается в символах, а не в байтах
+	nameLen := utf8.RuneCountInString(user.Name)
 	if strings.TrimSpace(user.Name) == "" {
-		errors = append(errors, "Name is required")
+		errors = append(errors, newMessage("name_required"))
+	} else if nameLen < s.config.NameMinLen {
+		errors = append(errors, newMessage("name_too_short", s.config.NameMinLen))
 	}
-	if len(user.Name) > 100 {
-		errors = append(errors, "Name must be less than 100 characters")
+	if nameLen > s.config.NameMaxLen {
+		errors = append(errors, newMessage("name_too_long", s.config.NameMaxLen))
+	}
+	if !utf8.ValidString(user.Name) {
+		errors = append(errors, newMessage("name_invalid_utf8"))
+	} else if strings.IndexFunc(user.Name, unicode.IsControl) >= 0 {
+		errors = append(errors, newMessage("name_control_chars"))
 	}
 
 	// Валидация email
-	if strings.TrimSpace(user.Email) == "" {
-		errors = append(errors, "Email is required")
+	errors = append(errors, s.validateEmail(user.Email)...)
+
+	// Валидация имени пользователя
+	errors = append(errors, validateUsername(user.Username)...)
+
+	// Валидация возраста
+	if user.Age < minUserAge {
+		errors = append(errors, newMessage("age_negative"))
 	}
-	if !isValidEmail(user.Email) {
-		errors = append(errors, "Invalid email format")
+	if user.Age > maxUserAge {
+		errors = append(errors, newMessage("age_too_high", maxUserAge))
 	}
 
-	// Валидация возраста
-	if user.Age < 0 {
-		errors = append(errors, "Age must be non-negative")
+	return errors
+}
+
+// validateEmail проверяет email по тем же правилам при создании, изменении
+// и в POST /validate/email
+func (s *Server) validateEmail(email string) []message {
+	var errors []message
+
+	if strings.TrimSpace(email) == "" {
+		errors = append(errors, newMessage("email_required"))
+	}
+	if !isValidEmail(email) {
+		errors = append(errors, newMessage("email_invalid"))
 	}
-	if user.Age > 150 {
-		errors = append(errors, "Age must be less than 150")
+	if strings.TrimSpace(email) != email {
+		errors = append(errors, newMessage("email_whitespace"))
+	}
+	if utf8.RuneCountInString(email) > s.config.EmailMaxLen {
+		errors = append(errors, newMessage("email_too_long", s.config.EmailMaxLen))
+	}
+	if !utf8.ValidString(email) {
+		errors = append(errors, newMessage("email_invalid_utf8"))
+	}
+
+	// Правила доменов проверяются только для email с доменом
+	if _, domain, ok := strings.Cut(email, "@"); ok && domain != "" {
+		domain = strings.ToLower(domain)
+		if len(s.config.EmailAllowedDomains) > 0 && !matchesDomain(domain, s.config.EmailAllowedDomains) {
+			errors = append(errors, newMessage("email_domain_not_allowed", domain))
+		}
+		if matchesDomain(domain, s.config.EmailBlockedDomains) {
+			errors = append(errors, newMessage("email_domain_blocked", domain))
+		}
 	}
 
 	return errors
 }
 
+// matchesDomain проверяет, совпадает ли домен с одним из списка или является
+// его поддоменом: правило example.com относится и к mail.example.com
+func matchesDomain(domain string, rules []string) bool {
+	for _, rule := range rules {
+		rule = strings.ToLower(rule)
+		if domain == rule || strings.HasSuffix(domain, "."+rule) {
+			return true
+		}
+	}
+	return false
+}
+
 // isValidEmail простая проверка email
 func isValidEmail(email string) bool {
 	return strings.Contains(email, "@") && strings.Contains(email, ".")
 }
 
 // healthHandler - проверка состояния сервера
-func healthHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"status":    "OK",
 		"timestamp": time.Now().Format(time.RFC3339),
@@ -146,289 +427,536 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 		"version":   "1.0.0",
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	// Нехватка места на томе с базой помечает сервис деградировавшим,
+	// чтобы мониторинг предупредил до того, как запись начнет падать
+	status := http.StatusOK
+	if s.config.DiskMinFreeBytes > 0 {
+		disk := s.checkDisk()
+		response["disk"] = disk
+		if disk.Status == "low" {
+			response["status"] = "DEGRADED"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, response)
 }
 
-// getUsersHandler - получение всех пользователей
-func getUsersHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT id, name, email, age, created_at FROM users ORDER BY created_at DESC")
+// userColumns - список колонок для выборки пользователя
+const userColumns = "id, name, email, username, age, status, created_at"
+
+// notDeleted - условие, исключающее мягко удаленных пользователей: их
+// помечают дедупликация и слияние учетных записей, запись остается в базе
+// и может быть восстановлена
+const notDeleted = "deleted_at IS NULL"
+
+// scanUser читает пользователя из строки результата.
+// Незаданное имя пользователя хранится как NULL и читается пустой строкой.
+func scanUser(row interface{ Scan(...interface{}) error }) (User, error) {
+	var user User
+	var username sql.NullString
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &username, &user.Age, &user.Status, &user.CreatedAt)
+	user.Username = username.String
+	return user, err
+}
+
+// queryUsers выполняет запрос на чтение и возвращает список пользователей
+func (st *store) queryUsers(ctx context.Context, query string, args ...interface{}) ([]User, error) {
+	rows, err := st.readDB.QueryContext(ctx, query, args...)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to fetch users"}`, http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	return scanUsers(rows)
+}
+
+// scanUsers читает всех пользователей из результата запроса и закрывает его
+func scanUsers(rows *sql.Rows) ([]User, error) {
 	defer rows.Close()
 
-	var users []User
+	// Пустой срез вместо nil, чтобы пустой результат кодировался как [], а не null
+	users := make([]User, 0)
 	for rows.Next() {
-		var user User
-		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Age, &user.CreatedAt)
+		user, err := scanUser(rows)
 		if err != nil {
-			http.Error(w, `{"error": "Failed to scan user"}`, http.StatusInternalServerError)
-			return
+			return nil, err
 		}
 		users = append(users, user)
 	}
 
 	// Проверка на ошибки после завершения итерации
-	if err = rows.Err(); err != nil {
-		http.Error(w, `{"error": "Database query error"}`, http.StatusInternalServerError)
-		return
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	return users, nil
+}
+
+// getUserByID возвращает пользователя по ID из основной базы: после записи
+// реплика может еще не содержать изменений
+func (st *store) getUserByID(ctx context.Context, id int64) (User, error) {
+	return scanUser(st.stmtGetUser.QueryRowContext(ctx, id))
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"users": users,
-		"count": len(users),
+// readUserByID возвращает пользователя по ID из соединения для чтения.
+// Одновременные запросы одного ID ждут общий запрос к базе. Он не зависит от
+// отмены запроса, начавшего его: отмененный клиент получает свою ошибку
+// контекста, остальные - результат. Результат не кешируется и следующий
+// запрос снова идет в базу.
+func (st *store) readUserByID(ctx context.Context, id int64) (User, error) {
+	flight := st.userReads.DoChan(strconv.FormatInt(id, 10), func() (interface{}, error) {
+		return scanUser(st.stmtReadUser.QueryRowContext(context.WithoutCancel(ctx), id))
 	})
+	select {
+	case result := <-flight:
+		return result.Val.(User), result.Err
+	case <-ctx.Done():
+		return User{}, ctx.Err()
+	}
 }
 
-// createUserHandler - создание нового пользователя
-func createUserHandler(w http.ResponseWriter, r *http.Request) {
+// parseUserID извлекает ID пользователя из URL
+func parseUserID(r *http.Request) (int, error) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, badRequest("Invalid user ID")
+	}
+	return userID, nil
+}
+
+// decodeUserRequest декодирует и валидирует тело запроса пользователя
+func (s *Server) decodeUserRequest(w http.ResponseWriter, r *http.Request) (UserRequest, error) {
+	body, err := s.readBody(w, r)
+	if err != nil {
+		return UserRequest{}, err
+	}
+	return s.parseUserRequest(body)
+}
+
+// parseUserRequest декодирует и валидирует прочитанное тело запроса
+func (s *Server) parseUserRequest(body []byte) (UserRequest, error) {
 	var userReq UserRequest
 
 	// Декодирование JSON
-	if err := json.NewDecoder(r.Body).Decode(&userReq); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Invalid JSON format",
-		})
-		return
+	if err := s.unmarshalBody(body, &userReq); err != nil {
+		return userReq, err
+	}
+
+	// Некорректный UTF-8 отклоняется до валидации, чтобы не сохранить
+	// данные, ломающие последующие JSON-ответы
+	if fields := invalidUTF8Fields(body); len(fields) > 0 {
+		details := make([]message, 0, len(fields))
+		for _, field := range fields {
+			details = append(details, newMessage("field_invalid_utf8", field))
+		}
+		return userReq, invalidEncoding(details)
 	}
 
 	// Валидация
-	if errors := validateUser(userReq); len(errors) > 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error:   "Validation failed",
-			Details: errors,
-		})
-		return
+	if errors := s.validateUser(userReq); len(errors) > 0 {
+		return userReq, validationFailed(errors)
 	}
+	return userReq, nil
+}
 
-	// Вставка в базу данных
-	result, err := db.Exec(
-		"INSERT INTO users (name, email, age) VALUES (?, ?, ?)",
-		userReq.Name, userReq.Email, userReq.Age,
-	)
+// isUniqueViolation проверяет, нарушено ли ограничение уникальности
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// getUsersHandler - получение всех пользователей
+func (s *Server) getUsersHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.checkQueryParams(r, listUsersParams...); err != nil {
+		return err
+	}
+
+	filter, err := s.parseUserFilter(r)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusConflict)
-			json.NewEncoder(w).Encode(ErrorResponse{
-				Error: "Email already exists",
-			})
-			return
+		return err
+	}
+	countMode, err := parseCountMode(r)
+	if err != nil {
+		return err
+	}
+	facets, err := parseFacets(r)
+	if err != nil {
+		return err
+	}
+	orderBy, err := s.parseUserSort(r)
+	if err != nil {
+		return err
+	}
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+	limit, capped := s.capResults(limit)
+
+	if err := s.checkBudget(r.Context()); err != nil {
+		return err
+	}
+
+	// Без фильтров и сортировки используется подготовленное выражение с тем
+	// же текстом
+	query := "SELECT " + userColumns + " FROM users" + filter.where() + " ORDER BY " + orderBy + " LIMIT ? OFFSET ?"
+	// Копия, чтобы запросы фасетов, дописывающие свои параметры к
+	// filter.args, не перезаписали limit и offset в общем массиве
+	args := append(append([]interface{}{}, filter.args...), limit, offset)
+	var users []User
+	if filter.empty() && orderBy == defaultUserOrder {
+		var rows *sql.Rows
+		rows, err = s.store.stmtListUsers.QueryContext(r.Context(), limit, offset)
+		if err == nil {
+			users, err = scanUsers(rows)
 		}
+	} else {
+		users, err = s.store.queryUsers(r.Context(), query, args...)
+	}
+	if err != nil {
+		return dbError(err, "Failed to fetch users")
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Failed to create user",
-		})
-		return
+	response := map[string]interface{}{
+		"users":      users,
+		"limit":      limit,
+		"offset":     offset,
+		"count_mode": countMode,
+		"capped":     capped,
+	}
+	if countMode != countNone {
+		if err := s.checkBudget(r.Context()); err != nil {
+			return err
+		}
+		count, mode, err := s.store.countUsers(r.Context(), countMode, filter)
+		if err != nil {
+			return dbError(err, "Failed to count users")
+		}
+		// total - то же значение под именем для постраничной навигации
+		response["count"] = count
+		response["total"] = count
+		response["count_mode"] = mode
+	}
+	if len(facets) > 0 {
+		if err := s.checkBudget(r.Context()); err != nil {
+			return err
+		}
+		counts, facetsCapped, err := s.store.countFacets(r.Context(), facets, filter, s.config.AbsoluteMaxResults)
+		if err != nil {
+			return dbError(err, "Failed to count facets")
+		}
+		response["facets"] = counts
+		if len(facetsCapped) > 0 {
+			response["facets_capped"] = facetsCapped
+		}
+	}
+	if s.config.DebugSQL {
+		response["_debug"] = sqlDebug(query, args)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+	return nil
+}
+
+// getRandomUsersHandler - получение случайных пользователей
+func (s *Server) getRandomUsersHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.checkQueryParams(r, "count"); err != nil {
+		return err
+	}
+
+	// Без параметра count возвращается один пользователь
+	countParam := r.URL.Query().Get("count")
+	count := 1
+	if countParam != "" {
+		n, err := strconv.Atoi(countParam)
+		if err != nil || n < 1 || n > maxRandomUsers {
+			return badRequest(fmt.Sprintf("Count must be between 1 and %d", maxRandomUsers))
+		}
+		count = n
+	}
+	count, capped := s.capResults(count)
+
+	// ORDER BY RANDOM() приемлем для небольших таблиц
+	users, err := s.store.queryUsers(r.Context(), "SELECT "+userColumns+" FROM users WHERE "+notDeleted+" ORDER BY RANDOM() LIMIT ?", count)
+	if err != nil {
+		return dbError(err, "Failed to fetch users")
+	}
+
+	if len(users) == 0 {
+		return notFound("No users found")
+	}
+
+	if countParam == "" {
+		writeJSON(w, http.StatusOK, s.withComputedFields(users[0], time.Now()))
+		return nil
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"users":  users,
+		"count":  len(users),
+		"capped": capped,
+	})
+	return nil
+}
+
+// batchGetUsersHandler - получение нескольких пользователей по списку ID.
+// Пользователи возвращаются в порядке ID в запросе, повторы отбрасываются.
+func (s *Server) batchGetUsersHandler(w http.ResponseWriter, r *http.Request) error {
+	var req BatchGetRequest
+
+	// Декодирование JSON
+	if err := s.decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+
+	// Удаление повторяющихся ID с сохранением порядка
+	seen := make(map[int]bool, len(req.IDs))
+	ids := make([]int, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 || len(ids) > maxBatchGetIDs {
+		return badRequest(fmt.Sprintf("Number of ids must be between 1 and %d", maxBatchGetIDs))
+	}
+
+	// ID сверх потолка не запрашиваются и не попадают ни в users, ни в missing
+	n, capped := s.capResults(len(ids))
+	ids = ids[:n]
+
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	query := "SELECT " + userColumns + " FROM users WHERE " + notDeleted + " AND id IN (" + strings.Join(placeholders, ", ") + ")"
+	matched, err := s.store.queryUsers(r.Context(), query, args...)
+	if err != nil {
+		return dbError(err, "Failed to fetch users")
+	}
+
+	found := make(map[int]User, len(matched))
+	for _, user := range matched {
+		found[user.ID] = user
+	}
+
+	// Восстановление порядка запроса
+	users := make([]User, 0, len(found))
+	missing := make([]int, 0)
+	for _, id := range ids {
+		if user, ok := found[id]; ok {
+			users = append(users, user)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"users":     users,
+		"not_found": missing,
+		"capped":    capped,
+	})
+	return nil
+}
+
+// getUserHandler - получение пользователя по ID.
+// Чтение идет в реплику, если она задана, поэтому только что созданный
+// пользователь может появиться с задержкой репликации.
+func (s *Server) getUserHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.store.readUserByID(r.Context(), int64(userID))
+	if err == sql.ErrNoRows {
+		return notFound("User not found")
+	}
+	if err != nil {
+		return dbError(err, "Failed to fetch user")
+	}
+
+	writeJSON(w, http.StatusOK, s.withComputedFields(user, time.Now()))
+	return nil
+}
+
+// createUserHandler - создание нового пользователя
+func (s *Server) createUserHandler(w http.ResponseWriter, r *http.Request) error {
+	body, err := s.readBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	// В строгом режиме ID, присланный клиентом, считается ошибкой,
+	// а не молча игнорируется
+	if s.config.StrictJSON && hasTopLevelField(body, "id") {
+		return badRequest("id must not be provided on create")
+	}
+
+	userReq, err := s.parseUserRequest(body)
+	if err != nil {
+		return err
+	}
+
+	// Вставка в базу данных
+	result, err := s.execWithRetry(r.Context(), func() (sql.Result, error) {
+		return s.store.stmtInsertUser.ExecContext(r.Context(), userReq.Name, userReq.Email, userReq.Username, userReq.Age)
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return uniqueConflict(err)
+		}
+		return dbError(err, "Failed to create user")
 	}
 
 	// Получение ID созданного пользователя
 	userID, err := result.LastInsertId()
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Failed to get user ID",
-		})
-		return
+		return dbError(err, "Failed to get user ID")
 	}
 
 	// Получение созданного пользователя
-	var createdUser User
-	err = db.QueryRow(
-		"SELECT id, name, email, age, created_at FROM users WHERE id = ?",
-		userID,
-	).Scan(&createdUser.ID, &createdUser.Name, &createdUser.Email, &createdUser.Age, &createdUser.CreatedAt)
-
+	createdUser, err := s.store.getUserByID(r.Context(), userID)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Failed to fetch created user",
-		})
-		return
+		return dbError(err, "Failed to fetch created user")
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(createdUser)
+	s.audit(r, auditCreate, createdUser.ID, createdFieldNames(userReq))
+
+	w.Header().Set("Location", s.externalURL(r, fmt.Sprintf("/users/%d", createdUser.ID)))
+	writeJSON(w, http.StatusCreated, s.withComputedFields(createdUser, time.Now()))
+	return nil
 }
 
 // updateUserHandler - обновление пользователя
-func updateUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateUserHandler(w http.ResponseWriter, r *http.Request) error {
 	// Получение ID из URL
-	vars := mux.Vars(r)
-	userID, err := strconv.Atoi(vars["id"])
+	userID, err := parseUserID(r)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Invalid user ID",
-		})
-		return
+		return err
 	}
 
-	var userReq UserRequest
-
-	// Декодирование JSON
-	if err := json.NewDecoder(r.Body).Decode(&userReq); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Invalid JSON format",
-		})
-		return
+	userReq, err := s.decodeUserRequest(w, r)
+	if err != nil {
+		return err
 	}
 
-	// Валидация
-	if errors := validateUser(userReq); len(errors) > 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error:   "Validation failed",
-			Details: errors,
-		})
-		return
-	}
-
-	// Обновление пользователя
-	result, err := db.Exec(
-		"UPDATE users SET name = ?, email = ?, age = ? WHERE id = ?",
-		userReq.Name, userReq.Email, userReq.Age, userID,
-	)
-	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusConflict)
-			json.NewEncoder(w).Encode(ErrorResponse{
-				Error: "Email already exists",
-			})
-			return
+	// Обновление и запись смены email выполняются в одной транзакции;
+	// исходная запись нужна для журнала и ответа только с изменениями
+	var previousUser User
+	err = s.withRetry(r.Context(), func() error {
+		tx, err := s.store.db.BeginTx(r.Context(), nil)
+		if err != nil {
+			return err
 		}
+		defer tx.Rollback()
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Failed to update user",
-		})
-		return
+		previousUser, err = s.updateUserInTx(r.Context(), tx, userID, userReq)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err == sql.ErrNoRows {
+		return notFound("User not found")
+	}
+	if err != nil {
+		if isUniqueViolation(err) {
+			return uniqueConflict(err)
+		}
+		return dbError(err, "Failed to update user")
 	}
 
-	// Проверка, что пользователь существует
-	rowsAffected, err := result.RowsAffected()
+	// Получение обновленного пользователя
+	updatedUser, err := s.store.getUserByID(r.Context(), int64(userID))
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Failed to check update result",
-		})
-		return
+		return dbError(err, "Failed to fetch updated user")
 	}
 
-	if rowsAffected == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "User not found",
-		})
-		return
+	s.audit(r, auditUpdate, userID, changedFieldNames(previousUser, updatedUser))
+
+	if wantsChangedOnly(r) {
+		w.Header().Set("Preference-Applied", "return=minimal")
+		writeJSON(w, http.StatusOK, changedFields(previousUser, updatedUser))
+		return nil
 	}
 
-	// Получение обновленного пользователя
-	var updatedUser User
-	err = db.QueryRow(
-		"SELECT id, name, email, age, created_at FROM users WHERE id = ?",
-		userID,
-	).Scan(&updatedUser.ID, &updatedUser.Name, &updatedUser.Email, &updatedUser.Age, &updatedUser.CreatedAt)
+	writeJSON(w, http.StatusOK, s.withComputedFields(updatedUser, time.Now()))
+	return nil
+}
 
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Failed to fetch updated user",
-		})
-		return
+// wantsChangedOnly проверяет, запросил ли клиент только измененные поля
+// через Prefer: return=minimal или ?changed_only=true
+func wantsChangedOnly(r *http.Request) bool {
+	return r.URL.Query().Get("changed_only") == "true" || prefers(r, "return=minimal")
+}
+
+// prefers проверяет наличие предпочтения в заголовке Prefer
+func prefers(r *http.Request, preference string) bool {
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.TrimSpace(pref) == preference {
+			return true
+		}
 	}
+	return false
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedUser)
+// changedFields возвращает ID и поля, значения которых изменились
+func changedFields(before, after User) map[string]interface{} {
+	changes := map[string]interface{}{"id": after.ID}
+	if before.Name != after.Name {
+		changes["name"] = after.Name
+	}
+	if before.Email != after.Email {
+		changes["email"] = after.Email
+	}
+	if before.Username != after.Username {
+		changes["username"] = after.Username
+	}
+	if before.Age != after.Age {
+		changes["age"] = after.Age
+	}
+	return changes
 }
 
 // deleteUserHandler - удаление пользователя
-func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteUserHandler(w http.ResponseWriter, r *http.Request) error {
 	// Получение ID из URL
-	vars := mux.Vars(r)
-	userID, err := strconv.Atoi(vars["id"])
+	userID, err := parseUserID(r)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Invalid user ID",
-		})
-		return
+		return err
 	}
 
 	// Удаление пользователя
-	result, err := db.Exec("DELETE FROM users WHERE id = ?", userID)
+	result, err := s.execWithRetry(r.Context(), func() (sql.Result, error) {
+		return s.store.db.ExecContext(r.Context(), "DELETE FROM users WHERE id = ? AND "+notDeleted, userID)
+	})
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Failed to delete user",
-		})
-		return
+		return dbError(err, "Failed to delete user")
 	}
 
 	// Проверка, что пользователь существовал
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "Failed to check delete result",
-		})
-		return
+		return dbError(err, "Failed to check delete result")
 	}
-
 	if rowsAffected == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Error: "User not found",
-		})
-		return
+		return notFound("User not found")
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(SuccessResponse{
-		Message: "User deleted successfully",
-	})
-}
-
-// corsMiddleware добавляет CORS заголовки
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	s.audit(r, auditDelete, userID, nil)
 
-		// Обработка preflight OPTIONS запросов
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	// Пустой ответ 204 для клиентов, которые его ожидают
+	if s.config.Delete204 || prefers(r, "return=minimal") {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
 
-		next.ServeHTTP(w, r)
+	writeJSON(w, http.StatusOK, SuccessResponse{
+		Message: "User deleted successfully",
 	})
+	return nil
 }
 
 // loggingMiddleware логирует HTTP запросы
@@ -449,3 +977,29 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		)
 	})
 }
+
+// adminMiddleware ограничивает доступ к эндпоинту токеном администратора.
+// Если ADMIN_TOKEN не задан, эндпоинт остается открытым.
+func (s *Server) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken != "" && !s.isAdmin(r) {
+			s.writeError(w, r, unauthorized("Admin authorization required"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin проверяет заголовок Authorization: Bearer <ADMIN_TOKEN> или Basic
+// с ADMIN_TOKEN в качестве пароля (для страниц, открываемых в браузере)
+func (s *Server) isAdmin(r *http.Request) bool {
+	if s.config.AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cachedCount - значение COUNT(*), кешируемое на METRICS_CACHE_TTL, чтобы
+// частые опросы /metrics не нагружали базу
+type cachedCount struct {
+	db    *sql.DB
+	query string
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	value   float64
+	expires time.Time
+}
+
+// get возвращает закешированное значение или выполняет запрос заново.
+// Ошибка запроса возвращает NaN, чтобы Prometheus не видел ложный ноль.
+func (c *cachedCount) get() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Now().Before(c.expires) {
+		return c.value
+	}
+
+	var count int64
+	if err := c.db.QueryRow(c.query).Scan(&count); err != nil {
+		log.Printf("Failed to collect metric: %v", err)
+		return math.NaN()
+	}
+
+	c.value = float64(count)
+	c.expires = time.Now().Add(c.ttl)
+	return c.value
+}
+
+// acceptErrors считает отказы accept из-за нехватки файловых дескрипторов
+var acceptErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "http_accept_fd_exhausted_total",
+	Help: "Connection accept failures caused by file descriptor exhaustion (EMFILE/ENFILE).",
+})
+
+// auditDropped считает записи аудита, отброшенные из-за заполненной очереди
+var auditDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "audit_entries_dropped_total",
+	Help: "Audit log entries dropped because the write queue was full.",
+})
+
+// registerMetrics регистрирует бизнес-метрики в reg. Значения вычисляются
+// лениво при опросе /metrics, а не по таймеру.
+func (s *Server) registerMetrics(reg prometheus.Registerer) {
+	usersTotal := &cachedCount{
+		db:    s.store.readDB,
+		query: "SELECT COUNT(*) FROM users WHERE " + notDeleted,
+		ttl:   s.config.MetricsCacheTTL,
+	}
+	usersToday := &cachedCount{
+		db:    s.store.readDB,
+		query: "SELECT COUNT(*) FROM users WHERE created_at >= date('now') AND " + notDeleted,
+		ttl:   s.config.MetricsCacheTTL,
+	}
+
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "users_total",
+		Help: "Current number of users.",
+	}, usersTotal.get))
+
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "users_created_today",
+		Help: "Number of users created since midnight UTC.",
+	}, usersToday.get))
+
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "db_circuit_breaker_state",
+		Help: "Database circuit breaker state: 0 closed, 1 half-open, 2 open.",
+	}, s.breaker.currentState))
+
+	if s.tenants != nil {
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "tenant_databases_open",
+			Help: "Number of open tenant databases.",
+		}, func() float64 { return float64(s.tenants.openCount()) }))
+	}
+
+	reg.MustRegister(acceptErrors, auditDropped)
+}
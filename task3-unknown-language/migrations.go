@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// expectedSchemaVersion - версия схемы, с которой работает этот бинарный
+// файл. Увеличивается вместе с добавлением миграции.
+const expectedSchemaVersion = 5
+
+// migration - версионированное изменение схемы. rebuildsTables отмечает
+// пересборку таблицы: на время миграции отключаются внешние ключи.
+type migration struct {
+	version        int
+	description    string
+	rebuildsTables bool
+	apply          func(tx *sql.Tx) error
+}
+
+// migrations - изменения схемы по возрастанию версий. Новые миграции
+// добавляются только в конец, примененные не изменяются.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "base schema",
+		// Базовые таблицы создаются идемпотентно, поэтому версия 1
+		// безопасно применяется и к базам, созданным до миграций
+		apply: func(tx *sql.Tx) error {
+			for _, create := range []func(*sql.Tx) error{createTable, createLabelsTable, createEmailChangesTable} {
+				if err := create(tx); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     2,
+		description: "user status",
+		apply: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'active'
+				CHECK (status IN ('active', 'suspended'))`)
+			return err
+		},
+	},
+	{
+		version:     3,
+		description: "username",
+		// Имя пользователя необязательно: NULL не участвует в уникальности,
+		// поэтому у любого числа пользователей оно может быть не задано
+		apply: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("ALTER TABLE users ADD COLUMN username TEXT"); err != nil {
+				return err
+			}
+			_, err := tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username COLLATE NOCASE)")
+			return err
+		},
+	},
+	{
+		version:     4,
+		description: "soft delete",
+		// Мягко удаленная запись остается в таблице; до версии 5 ее email и
+		// имя пользователя оставались занятыми
+		apply: func(tx *sql.Tx) error {
+			_, err := tx.Exec("ALTER TABLE users ADD COLUMN deleted_at DATETIME")
+			return err
+		},
+	},
+	{
+		version:        5,
+		description:    "unique email and username among active users",
+		rebuildsTables: true,
+		// UNIQUE у столбца email нельзя снять без пересборки таблицы.
+		// Частичные индексы освобождают email и имя мягко удаленного
+		// пользователя для повторной регистрации.
+		apply: rebuildUsersWithPartialUnique,
+	},
+}
+
+// rebuildUsersWithPartialUnique пересобирает таблицу users по процедуре
+// SQLite (новая таблица, копирование, замена) без UNIQUE у email и создает
+// уникальные индексы только по записям без deleted_at. ID и счетчик
+// AUTOINCREMENT сохраняются, чтобы ID удаленных пользователей не выдавались
+// повторно.
+func rebuildUsersWithPartialUnique(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE users_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			email TEXT NOT NULL,
+			age INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'suspended')),
+			username TEXT,
+			deleted_at DATETIME
+		)`,
+		`INSERT INTO users_new (id, name, email, age, created_at, status, username, deleted_at)
+			SELECT id, name, email, age, created_at, status, username, deleted_at FROM users`,
+		`UPDATE sqlite_sequence SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'users')
+			WHERE name = 'users_new' AND EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'users')`,
+		"DROP TABLE users",
+		"ALTER TABLE users_new RENAME TO users",
+		"CREATE UNIQUE INDEX idx_users_email ON users (email) WHERE " + notDeleted,
+		"CREATE UNIQUE INDEX idx_users_username ON users (username COLLATE NOCASE) WHERE " + notDeleted,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate применяет недостающие миграции по порядку, каждую в своей
+// транзакции вместе с записью версии в schema_migrations. База с версией
+// новее expectedSchemaVersion не изменяется: старый бинарный файл после
+// отката мог бы испортить данные, записанные по новой схеме.
+func (st *store) migrate() error {
+	if last := migrations[len(migrations)-1].version; last != expectedSchemaVersion {
+		return fmt.Errorf("last migration is version %d, but expectedSchemaVersion is %d", last, expectedSchemaVersion)
+	}
+
+	_, err := st.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	// Без таблицы пользователей записи о версиях недостоверны: таблица
+	// создается заново, и все миграции применяются повторно
+	var tables int
+	err = st.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'").Scan(&tables)
+	if err != nil {
+		return err
+	}
+	if tables == 0 {
+		if _, err := st.db.Exec("DELETE FROM schema_migrations"); err != nil {
+			return err
+		}
+	}
+
+	current, err := st.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > expectedSchemaVersion {
+		return fmt.Errorf("database schema is version %d, but this binary supports up to version %d; run a newer release", current, expectedSchemaVersion)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := st.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		log.Printf("Applied migration %d: %s", m.version, m.description)
+	}
+	log.Printf("Database schema version %d", expectedSchemaVersion)
+	return nil
+}
+
+// applyMigration применяет одну миграцию и записывает ее версию. Миграция с
+// пересборкой таблиц выполняется с отключенными внешними ключами: внутри
+// транзакции их не отключить, а с ними DROP TABLE каскадно удалил бы метки
+// и журнал смены email. Перед фиксацией ссылки проверяются заново.
+func (st *store) applyMigration(m migration) error {
+	ctx := context.Background()
+	conn, err := st.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.rebuildsTables {
+		var foreignKeys bool
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+			return err
+		}
+		if foreignKeys {
+			if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+				return err
+			}
+			defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.apply(tx); err != nil {
+		return err
+	}
+	if m.rebuildsTables {
+		rows, err := tx.Query("PRAGMA foreign_key_check")
+		if err != nil {
+			return err
+		}
+		broken := rows.Next()
+		rows.Close()
+		if broken {
+			return fmt.Errorf("foreign key check failed after rebuilding tables")
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", m.version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// schemaVersion возвращает последнюю примененную версию схемы
+func (st *store) schemaVersion() (int, error) {
+	var version int
+	err := st.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log"
+)
+
+// withReadOnlyTx выполняет fn в транзакции только на чтение на соединении readDB.
+// Драйвер SQLite игнорирует TxOptions.ReadOnly, поэтому на время транзакции
+// соединение переводится в PRAGMA query_only: любая попытка записи завершается
+// ошибкой, а SQLite не берет блокировку на запись.
+func (st *store) withReadOnlyTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	conn, err := st.readDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		return err
+	}
+	defer resetQueryOnly(conn)
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// resetQueryOnly возвращает соединению возможность записи перед возвратом в пул.
+// Если сбросить режим не удалось, соединение закрывается, чтобы оно не досталось
+// обработчику записи.
+func resetQueryOnly(conn *sql.Conn) {
+	if _, err := conn.ExecContext(context.Background(), "PRAGMA query_only = OFF"); err != nil {
+		log.Printf("Failed to reset query_only, discarding connection: %v", err)
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// readBody читает тело запроса целиком с ограничением размера.
+// Тело короче заявленного Content-Length считается ошибкой клиента.
+// Тело с Content-Encoding: gzip распаковывается, ограничение размера
+// действует и на сжатое, и на распакованное тело.
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.config.MaxBodyBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxErr):
+			return nil, apiError{
+				Status:  http.StatusRequestEntityTooLarge,
+				Code:    "body_too_large",
+				Message: fmt.Sprintf("Request body must not exceed %d bytes", maxErr.Limit),
+			}
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return nil, badRequest("Request body is shorter than declared Content-Length")
+		default:
+			return nil, badRequest("Failed to read request body")
+		}
+	}
+
+	if r.ContentLength >= 0 && int64(len(body)) != r.ContentLength {
+		return nil, badRequest("Request body length does not match Content-Length")
+	}
+
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		return s.gunzipBody(body)
+	default:
+		return nil, apiError{
+			Status:  http.StatusUnsupportedMediaType,
+			Code:    "unsupported_encoding",
+			Message: fmt.Sprintf("Unsupported Content-Encoding %q: use gzip", encoding),
+		}
+	}
+}
+
+// gunzipBody распаковывает сжатое тело. Распакованный размер ограничен
+// MAX_BODY_BYTES, чтобы маленький архив не развернулся в гигабайты.
+func (s *Server) gunzipBody(compressed []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, badRequest("Malformed gzip request body")
+	}
+	defer zr.Close()
+
+	body, err := io.ReadAll(io.LimitReader(zr, s.config.MaxBodyBytes+1))
+	if err != nil {
+		return nil, badRequest("Malformed gzip request body")
+	}
+	if int64(len(body)) > s.config.MaxBodyBytes {
+		return nil, apiError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    "body_too_large",
+			Message: fmt.Sprintf("Decompressed request body must not exceed %d bytes", s.config.MaxBodyBytes),
+		}
+	}
+	return body, nil
+}
+
+// decodeJSON читает тело запроса и декодирует его в v
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	body, err := s.readBody(w, r)
+	if err != nil {
+		return err
+	}
+	return s.unmarshalBody(body, v)
+}
+
+// unmarshalBody проверяет вложенность и декодирует прочитанное тело в v
+func (s *Server) unmarshalBody(body []byte, v interface{}) error {
+	if err := s.checkJSONStructure(body); err != nil {
+		return err
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(v); err != nil {
+		// Ошибки валидации из UnmarshalJSON передаются как есть
+		var apiErr apiError
+		if errors.As(err, &apiErr) {
+			return apiErr
+		}
+		return badRequest("Invalid JSON format")
+	}
+	return nil
+}
+
+// checkJSONStructure проверяет вложенность тела и в строгом режиме
+// повторяющиеся ключи, не декодируя его
+func (s *Server) checkJSONStructure(body []byte) error {
+	if err := checkJSONDepth(body, s.config.JSONMaxDepth); err != nil {
+		return err
+	}
+	if s.config.StrictJSON {
+		return checkDuplicateKeys(body)
+	}
+	return nil
+}
+
+// readJSONArray читает тело-массив JSON и проверяет его структуру.
+// Элементы декодируются позже по одному через eachJSONArrayItem.
+func (s *Server) readJSONArray(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := s.readBody(w, r)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkJSONStructure(body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// eachJSONArrayItem потоково читает элементы массива JSON и передает их fn
+// по одному, не декодируя массив целиком: в памяти одновременно находится
+// только текущий элемент. Возвращает количество обработанных элементов.
+func eachJSONArrayItem(body []byte, fn func(i int, item json.RawMessage) error) (int, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if token, err := dec.Token(); err != nil || token != json.Delim('[') {
+		return 0, badRequest("Invalid JSON format")
+	}
+
+	n := 0
+	for dec.More() {
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return n, badRequest("Invalid JSON format")
+		}
+		if err := fn(n, item); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if _, err := dec.Token(); err != nil {
+		return n, badRequest("Invalid JSON format")
+	}
+	return n, nil
+}
+
+// UnmarshalJSON декодирует запрос пользователя, принимая возраст, записанный
+// дробным числом с нулевой дробной частью (30.0 или 3e1), как целый.
+// Действительно дробный возраст отклоняется с 422, строка "30" - как
+// некорректный JSON, так же как до появления этого правила.
+func (u *UserRequest) UnmarshalJSON(data []byte) error {
+	type plain UserRequest
+	aux := struct {
+		*plain
+		Age json.RawMessage `json:"age"`
+	}{plain: (*plain)(u)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(aux.Age) == 0 || string(aux.Age) == "null" {
+		return nil
+	}
+
+	var number json.Number
+	if aux.Age[0] == '"' || json.Unmarshal(aux.Age, &number) != nil {
+		return &json.UnmarshalTypeError{Value: "age " + string(aux.Age), Type: reflect.TypeOf(0), Field: "age"}
+	}
+	if n, err := number.Int64(); err == nil && n >= math.MinInt32 && n <= math.MaxInt32 {
+		u.Age = int(n)
+		return nil
+	}
+	f, err := number.Float64()
+	if err != nil || f != math.Trunc(f) || f < math.MinInt32 || f > math.MaxInt32 {
+		return invalidNumber([]message{newMessage("age_not_integer")})
+	}
+	u.Age = int(f)
+	return nil
+}
+
+// hasTopLevelField проверяет наличие поля в JSON-объекте верхнего уровня
+func hasTopLevelField(body []byte, field string) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false
+	}
+	_, ok := fields[field]
+	return ok
+}
+
+// invalidUTF8Fields возвращает поля верхнего уровня, значения которых содержат
+// байты не в UTF-8. Проверяется сырое тело: при декодировании encoding/json
+// молча заменяет такие байты на U+FFFD, и после него ошибку уже не увидеть.
+func invalidUTF8Fields(body []byte) []string {
+	if utf8.Valid(body) {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+	var invalid []string
+	for name, raw := range fields {
+		if !utf8.Valid(raw) {
+			invalid = append(invalid, name)
+		}
+	}
+	sort.Strings(invalid)
+	return invalid
+}
+
+// checkJSONDepth потоково просматривает токены JSON до полного декодирования
+// и отклоняет документы с вложенностью больше maxDepth
+func checkJSONDepth(body []byte, maxDepth int) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return badRequest("Invalid JSON format")
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxDepth {
+				return badRequest("JSON too deeply nested")
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// jsonFrame - открытый объект или массив при просмотре токенов JSON
+type jsonFrame struct {
+	object    bool
+	keys      map[string]bool
+	expectKey bool
+}
+
+// checkDuplicateKeys потоково просматривает токены JSON и отклоняет
+// объекты с повторяющимися ключами. Декодер молча берет последнее
+// значение, поэтому {"age":5,"age":200} иначе сохранил бы 200.
+func checkDuplicateKeys(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	var stack []*jsonFrame
+	// valueDone отмечает, что значение в объекте прочитано и дальше идет ключ
+	valueDone := func() {
+		if n := len(stack); n > 0 && stack[n-1].object {
+			stack[n-1].expectKey = true
+		}
+	}
+
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return badRequest("Invalid JSON format")
+		}
+
+		if n := len(stack); n > 0 && stack[n-1].expectKey {
+			if key, ok := token.(string); ok {
+				top := stack[n-1]
+				if top.keys[key] {
+					return apiError{
+						Status:  http.StatusBadRequest,
+						Code:    "duplicate_key",
+						Message: "Duplicate keys in JSON object",
+						Details: []string{key},
+					}
+				}
+				top.keys[key] = true
+				top.expectKey = false
+				continue
+			}
+		}
+
+		switch token {
+		case json.Delim('{'):
+			stack = append(stack, &jsonFrame{object: true, keys: make(map[string]bool), expectKey: true})
+		case json.Delim('['):
+			stack = append(stack, &jsonFrame{})
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			valueDone()
+		default:
+			valueDone()
+		}
+	}
+}
+
+// checkQueryParams в строгом режиме (STRICT_QUERY) отклоняет параметры
+// запроса, которые не входят в список известных для эндпоинта
+func (s *Server) checkQueryParams(r *http.Request, known ...string) error {
+	if !s.config.StrictQuery {
+		return nil
+	}
+
+	allowed := make(map[string]bool, len(known))
+	for _, name := range known {
+		allowed[name] = true
+	}
+
+	var unknown []string
+	for name := range r.URL.Query() {
+		if !allowed[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return badRequest("Unknown query parameter: " + strings.Join(unknown, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// isBusyError распознает временную блокировку базы (SQLITE_BUSY/SQLITE_LOCKED)
+func isBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// execWithRetry выполняет запись и повторяет ее при временной блокировке
+// базы до DB_RETRY_ATTEMPTS раз с экспоненциальной задержкой и джиттером
+func (s *Server) execWithRetry(ctx context.Context, exec func() (sql.Result, error)) (sql.Result, error) {
+	var result sql.Result
+	err := s.withRetry(ctx, func() error {
+		var err error
+		result, err = exec()
+		return err
+	})
+	return result, err
+}
+
+// withRetry повторяет fn при временной блокировке базы так же, как
+// execWithRetry. Подходит для транзакций: fn должна начинать ее заново.
+func (s *Server) withRetry(ctx context.Context, fn func() error) error {
+	backoff := s.config.DBRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isBusyError(err) || attempt >= s.config.DBRetryAttempts {
+			return err
+		}
+
+		// Случайная задержка в диапазоне [backoff/2, backoff)
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import "net/http"
+
+// fieldSchema описывает ограничения одного поля пользователя
+type fieldSchema struct {
+	Type      string `json:"type"`
+	Required  bool   `json:"required"`
+	MinLength *int   `json:"min_length,omitempty"`
+	MaxLength *int   `json:"max_length,omitempty"`
+	Minimum   *int   `json:"minimum,omitempty"`
+	Maximum   *int   `json:"maximum,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+
+	// Зарезервированные значения, которые нельзя занять
+	Reserved []string `json:"reserved,omitempty"`
+
+	// Правила доменов email; действуют и на поддомены
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	BlockedDomains []string `json:"blocked_domains,omitempty"`
+}
+
+// labelsSchema описывает ограничения меток пользователя
+type labelsSchema struct {
+	MaxLabels      int    `json:"max_labels"`
+	KeyPattern     string `json:"key_pattern"`
+	MaxKeyLength   int    `json:"max_key_length"`
+	MaxValueLength int    `json:"max_value_length"`
+}
+
+// userSchema - действующие правила валидации пользователя
+type userSchema struct {
+	Fields   map[string]fieldSchema `json:"fields"`
+	Required []string               `json:"required"`
+	Labels   labelsSchema           `json:"labels"`
+}
+
+// currentUserSchema строит описание правил из тех же констант и конфигурации,
+// что использует validateUser, чтобы описание не расходилось с проверкой
+func (s *Server) currentUserSchema() userSchema {
+	intPtr := func(v int) *int { return &v }
+
+	return userSchema{
+		Fields: map[string]fieldSchema{
+			"name": {
+				Type:      "string",
+				Required:  true,
+				MinLength: intPtr(s.config.NameMinLen),
+				MaxLength: intPtr(s.config.NameMaxLen),
+			},
+			"email": {
+				Type:      "string",
+				Required:  true,
+				MaxLength: intPtr(s.config.EmailMaxLen),
+				Format:    "email",
+
+				AllowedDomains: s.config.EmailAllowedDomains,
+				BlockedDomains: s.config.EmailBlockedDomains,
+			},
+			"username": {
+				Type:      "string",
+				MinLength: intPtr(minUsernameLen),
+				MaxLength: intPtr(maxUsernameLen),
+				Pattern:   usernamePattern.String(),
+				Reserved:  reservedUsernames,
+			},
+			"age": {
+				Type:    "integer",
+				Minimum: intPtr(minUserAge),
+				Maximum: intPtr(maxUserAge),
+			},
+		},
+		Required: []string{"name", "email"},
+		Labels: labelsSchema{
+			MaxLabels:      maxLabelsPerUser,
+			KeyPattern:     labelKeyPattern.String(),
+			MaxKeyLength:   maxLabelKeyLen,
+			MaxValueLength: maxLabelValueLen,
+		},
+	}
+}
+
+// userSchemaHandler - описание правил валидации для клиентов
+func (s *Server) userSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.currentUserSchema())
+}
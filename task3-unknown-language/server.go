@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// keepAliveListener настраивает TCP keep-alive для каждого принятого
+// TCP-соединения
+type keepAliveListener struct {
+	net.Listener
+	enabled bool
+	period  time.Duration
+}
+
+// Пределы паузы перед повтором accept при нехватке файловых дескрипторов
+const (
+	acceptBackoffMin = 5 * time.Millisecond
+	acceptBackoffMax = time.Second
+)
+
+// Accept принимает соединение и применяет настройки keep-alive. При
+// исчерпании файловых дескрипторов (EMFILE, ENFILE) accept повторяется
+// с растущей паузой, а не возвращает ошибку: иначе сервер либо крутится
+// в цикле, либо останавливается, хотя дескрипторы вскоре освободятся.
+func (l keepAliveListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	backoff := acceptBackoffMin
+	for {
+		var err error
+		conn, err = l.Listener.Accept()
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EMFILE) && !errors.Is(err, syscall.ENFILE) {
+			return nil, err
+		}
+
+		acceptErrors.Inc()
+		log.Printf("WARNING: out of file descriptors, cannot accept connections (%v); retrying in %v", err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, acceptBackoffMax)
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+	if err := tcpConn.SetKeepAlive(l.enabled); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if l.enabled && l.period > 0 {
+		if err := tcpConn.SetKeepAlivePeriod(l.period); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// newHTTPServer создает HTTP-сервер с таймаутами и keep-alive из конфигурации
+func (s *Server) newHTTPServer(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     handler,
+		IdleTimeout: s.config.IdleTimeout,
+	}
+	server.SetKeepAlivesEnabled(s.config.HTTPKeepAlive)
+	return server
+}
+
+// serve запускает сервер и при SIGINT/SIGTERM плавно останавливает его:
+// новые keep-alive соединения больше не удерживаются, активные запросы
+// дорабатывают в течение SHUTDOWN_TIMEOUT.
+func (s *Server) serve(server *http.Server) error {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	listener := keepAliveListener{
+		Listener: ln,
+		enabled:  s.config.TCPKeepAlive,
+		period:   s.config.TCPKeepAlivePeriod,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.serveUntil(ctx, server, listener)
+}
+
+// serveUntil обслуживает listener до отмены ctx, затем плавно
+// останавливает сервер
+func (s *Server) serveUntil(ctx context.Context, server *http.Server, listener net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, draining connections...")
+	server.SetKeepAlivesEnabled(false)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("Server stopped")
+	return nil
+}
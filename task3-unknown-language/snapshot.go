@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotHandler - выгрузка копии базы данных для резервного копирования
+// без доступа к серверу. VACUUM INTO пишет во временный файл согласованную
+// копию на момент начала чтения; блокировка на запись при этом не берется,
+// и запросы на запись продолжают выполняться. Файл удаляется после отправки.
+//
+// Поддерживается Range для докачки прерванной выгрузки. Каждый запрос
+// создает снимок заново, поэтому ETag - хеш содержимого: при If-Range с
+// устаревшим ETag (данные изменились) отдается весь новый снимок.
+func (s *Server) snapshotHandler(w http.ResponseWriter, r *http.Request) error {
+	dir, err := os.MkdirTemp("", "user-api-snapshot-")
+	if err != nil {
+		return internalError("Failed to create snapshot")
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "users.db")
+	if _, err := s.store.db.ExecContext(r.Context(), "VACUUM INTO ?", path); err != nil {
+		return dbError(err, "Failed to create snapshot")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return internalError("Failed to read snapshot")
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return internalError("Failed to read snapshot")
+	}
+
+	name := fmt.Sprintf("users-%s.db", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash.Sum(nil))+`"`)
+
+	// ServeContent отвечает 206 на Range, 416 на недопустимый диапазон и
+	// сверяет If-Range с ETag
+	http.ServeContent(w, r, name, time.Time{}, file)
+	return nil
+}
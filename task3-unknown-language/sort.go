@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultUserOrder - порядок списка пользователей без ?sort=; совпадает
+// с порядком подготовленного выражения stmtListUsers. id различает
+// пользователей, созданных в одну секунду, чтобы страницы не пересекались.
+const defaultUserOrder = "created_at DESC, id DESC"
+
+// userSortColumns - поля, допустимые в ?sort=
+var userSortColumns = map[string]bool{"id": true, "name": true, "email": true, "age": true, "created_at": true}
+
+// nameCollation возвращает правило сравнения имен из NAME_COLLATION.
+// NOCASE сравнивает латиницу без учета регистра, BINARY - побайтно.
+func (s *Server) nameCollation() string {
+	if s.config.NameCollation == "binary" {
+		return "BINARY"
+	}
+	return "NOCASE"
+}
+
+// parseUserSort читает ?sort=field или ?sort=-field (по убыванию) и
+// возвращает выражение ORDER BY. При равных значениях порядок задает id.
+func (s *Server) parseUserSort(r *http.Request) (string, error) {
+	value := r.URL.Query().Get("sort")
+	if value == "" {
+		return defaultUserOrder, nil
+	}
+
+	field, direction := value, "ASC"
+	if strings.HasPrefix(value, "-") {
+		field, direction = value[1:], "DESC"
+	}
+	if !userSortColumns[field] {
+		return "", badRequest("Invalid sort: use id, name, email, age or created_at, with '-' for descending")
+	}
+
+	column := field
+	if field == "name" {
+		column = "name COLLATE " + s.nameCollation()
+	}
+	if field == "id" {
+		return "id " + direction, nil
+	}
+	return column + " " + direction + ", id " + direction, nil
+}
+
+// maxSignupEdgeUsers - максимальное значение limit для /users/first и /users/recent
+const maxSignupEdgeUsers = 100
+
+// firstUsersHandler - самые ранние регистрации, от первой
+func (s *Server) firstUsersHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.signupEdgeUsers(w, r, "created_at ASC, id ASC")
+}
+
+// recentUsersHandler - самые новые регистрации, от последней
+func (s *Server) recentUsersHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.signupEdgeUsers(w, r, "created_at DESC, id DESC")
+}
+
+// signupEdgeUsers возвращает ?limit= пользователей (по умолчанию 10) в
+// порядке регистрации orderBy. Это короткий путь для интерфейсов вместо
+// GET /users?sort=created_at с подсчетом и фильтрами.
+func (s *Server) signupEdgeUsers(w http.ResponseWriter, r *http.Request, orderBy string) error {
+	if err := s.checkQueryParams(r, "limit"); err != nil {
+		return err
+	}
+
+	limit := 10
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSignupEdgeUsers {
+			return badRequest(fmt.Sprintf("Limit must be between 1 and %d", maxSignupEdgeUsers))
+		}
+		limit = n
+	}
+	limit, capped := s.capResults(limit)
+
+	users, err := s.store.queryUsers(r.Context(), "SELECT "+userColumns+" FROM users WHERE "+notDeleted+" ORDER BY "+orderBy+" LIMIT ?", limit)
+	if err != nil {
+		return dbError(err, "Failed to fetch users")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"users":  users,
+		"count":  len(users),
+		"capped": capped,
+	})
+	return nil
+}
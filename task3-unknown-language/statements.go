@@ -0,0 +1,29 @@
+package main
+
+// prepare подготавливает выражения для частых запросов. Они создаются один
+// раз при открытии базы; database/sql сам подготавливает их заново на новых
+// соединениях пула, поэтому они не привязаны к конкретному соединению.
+// Выборка по ID есть в двух вариантах: stmtGetUser читает основную базу
+// сразу после записи, stmtReadUser обслуживает GET /users/{id} с реплики.
+func (st *store) prepare() error {
+	var err error
+	getUser := "SELECT " + userColumns + " FROM users WHERE id = ? AND " + notDeleted
+
+	st.stmtGetUser, err = st.db.Prepare(getUser)
+	if err != nil {
+		return err
+	}
+
+	st.stmtReadUser, err = st.readDB.Prepare(getUser)
+	if err != nil {
+		return err
+	}
+
+	st.stmtListUsers, err = st.readDB.Prepare("SELECT " + userColumns + " FROM users WHERE " + notDeleted + " ORDER BY " + defaultUserOrder + " LIMIT ? OFFSET ?")
+	if err != nil {
+		return err
+	}
+
+	st.stmtInsertUser, err = st.db.Prepare("INSERT INTO users (name, email, username, age) VALUES (?, ?, NULLIF(?, ''), ?)")
+	return err
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// dateFormat - формат дат в параметрах статистики
+const dateFormat = "2006-01-02"
+
+// maxSignupPeriods - максимальное количество периодов в ряду регистраций
+const maxSignupPeriods = 1000
+
+// SignupPeriod - количество регистраций за период
+type SignupPeriod struct {
+	Period string `json:"period"`
+	Count  int    `json:"count"`
+}
+
+// signupGranularity описывает группировку по периоду: SQL-выражение для
+// начала периода, формат его метки и шаг до следующего периода
+type signupGranularity struct {
+	expr   string
+	format string
+	start  func(t time.Time) time.Time
+	next   func(t time.Time) time.Time
+}
+
+var signupGranularities = map[string]signupGranularity{
+	"day": {
+		expr:   "strftime('%Y-%m-%d', created_at)",
+		format: dateFormat,
+		start:  func(t time.Time) time.Time { return t },
+		next:   func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	},
+	"week": {
+		// Неделя начинается с понедельника
+		expr:   "date(created_at, 'weekday 0', '-6 days')",
+		format: dateFormat,
+		start: func(t time.Time) time.Time {
+			return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	},
+	"month": {
+		expr:   "strftime('%Y-%m', created_at)",
+		format: "2006-01",
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	},
+}
+
+// signupStatsHandler - ряд количества регистраций по дням, неделям или
+// месяцам за диапазон дат. Периоды без регистраций заполняются нулями.
+func (s *Server) signupStatsHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.checkQueryParams(r, "from", "to", "granularity", "fresh"); err != nil {
+		return err
+	}
+	query := r.URL.Query()
+
+	granularityName := query.Get("granularity")
+	if granularityName == "" {
+		granularityName = "day"
+	}
+	granularity, ok := signupGranularities[granularityName]
+	if !ok {
+		return badRequest("Invalid granularity: use day, week or month")
+	}
+
+	// По умолчанию - последние 30 дней
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if value := query.Get("to"); value != "" {
+		parsed, err := time.Parse(dateFormat, value)
+		if err != nil {
+			return badRequest("Invalid to date: use YYYY-MM-DD")
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -29)
+	if value := query.Get("from"); value != "" {
+		parsed, err := time.Parse(dateFormat, value)
+		if err != nil {
+			return badRequest("Invalid from date: use YYYY-MM-DD")
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		return badRequest("from must not be after to")
+	}
+
+	// Все периоды диапазона, чтобы ряд был непрерывным
+	var periods []string
+	for t := granularity.start(from); !t.After(to); t = granularity.next(t) {
+		periods = append(periods, t.Format(granularity.format))
+		if len(periods) > maxSignupPeriods {
+			return badRequest(fmt.Sprintf("Date range must not exceed %d periods", maxSignupPeriods))
+		}
+	}
+
+	if err := s.checkBudget(r.Context()); err != nil {
+		return err
+	}
+
+	// Отчетный запрос выполняется в транзакции только на чтение,
+	// чтобы не создавать конкуренции с записью
+	counts := make(map[string]int)
+	err := s.store.withReadOnlyTx(r.Context(), func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(r.Context(),
+			"SELECT "+granularity.expr+" AS period, COUNT(*) FROM users"+
+				" WHERE created_at >= ? AND created_at < ? AND "+notDeleted+" GROUP BY period",
+			from.Format(sqliteTimeFormat), to.AddDate(0, 0, 1).Format(sqliteTimeFormat),
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var period string
+			var count int
+			if err := rows.Scan(&period, &count); err != nil {
+				return err
+			}
+			counts[period] = count
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return dbError(err, "Failed to fetch signup stats")
+	}
+
+	// Ряд длиннее ABSOLUTE_MAX_RESULTS обрезается с конца, как остальные списки
+	limit, capped := s.capResults(len(periods))
+	periods = periods[:limit]
+
+	series := make([]SignupPeriod, 0, len(periods))
+	for _, period := range periods {
+		series = append(series, SignupPeriod{Period: period, Count: counts[period]})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"from":        from.Format(dateFormat),
+		"to":          to.Format(dateFormat),
+		"granularity": granularityName,
+		"series":      series,
+		"capped":      capped,
+	})
+	return nil
+}
+
+// maxDomainStats - максимальное значение limit для /stats/domains
+const maxDomainStats = 100
+
+// DomainCount - количество пользователей с email в домене
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int    `json:"count"`
+}
+
+// emailDomainExpr - домен, часть email после первого '@' в нижнем регистре
+const emailDomainExpr = "lower(trim(substr(email, instr(email, '@') + 1)))"
+
+// domainStatsHandler - самые частые домены email. Email без '@' или с пустым
+// доменом не учитываются в рейтинге и считаются отдельно как malformed.
+func (s *Server) domainStatsHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.checkQueryParams(r, "limit", "fresh"); err != nil {
+		return err
+	}
+
+	limit := 10
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDomainStats {
+			return badRequest(fmt.Sprintf("Limit must be between 1 and %d", maxDomainStats))
+		}
+		limit = n
+	}
+	limit, capped := s.capResults(limit)
+
+	if err := s.checkBudget(r.Context()); err != nil {
+		return err
+	}
+
+	domains := []DomainCount{}
+	var malformed int
+	err := s.store.withReadOnlyTx(r.Context(), func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(r.Context(),
+			"SELECT "+emailDomainExpr+" AS domain, COUNT(*) AS n FROM users"+
+				" WHERE instr(email, '@') > 0 AND "+emailDomainExpr+" <> '' AND "+notDeleted+
+				" GROUP BY domain ORDER BY n DESC, domain LIMIT ?",
+			limit,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var d DomainCount
+			if err := rows.Scan(&d.Domain, &d.Count); err != nil {
+				return err
+			}
+			domains = append(domains, d)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return tx.QueryRowContext(r.Context(),
+			"SELECT COUNT(*) FROM users WHERE (instr(email, '@') = 0 OR "+emailDomainExpr+" = '') AND "+notDeleted,
+		).Scan(&malformed)
+	})
+	if err != nil {
+		return dbError(err, "Failed to fetch domain stats")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"domains":   domains,
+		"malformed": malformed,
+		"capped":    capped,
+	})
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Статусы пользователя
+const (
+	userStatusActive    = "active"
+	userStatusSuspended = "suspended"
+)
+
+// suspendUserHandler - блокировка пользователя
+func (s *Server) suspendUserHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.setUserStatus(w, r, userStatusSuspended)
+}
+
+// activateUserHandler - разблокировка пользователя
+func (s *Server) activateUserHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.setUserStatus(w, r, userStatusActive)
+}
+
+// setUserStatus меняет статус пользователя в транзакции и возвращает
+// обновленного пользователя. Повторная установка того же статуса не ошибка.
+func (s *Server) setUserStatus(w http.ResponseWriter, r *http.Request, status string) error {
+	userID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	tx, err := s.store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET status = ? WHERE id = ? AND "+notDeleted, status, userID)
+	if err != nil {
+		return dbError(err, "Failed to update user status")
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+	user, err := scanUser(tx.StmtContext(ctx, s.store.stmtGetUser).QueryRowContext(ctx, userID))
+	if err != nil {
+		return dbError(err, "Failed to fetch user")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError(err, "Failed to commit transaction")
+	}
+	s.audit(r, auditStatus, userID, []string{"status"})
+
+	writeJSON(w, http.StatusOK, s.withComputedFields(user, time.Now()))
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// store - база данных пользователей: соединение для записи, соединение для
+// чтения и подготовленные выражения частых запросов
+type store struct {
+	db *sql.DB
+
+	// readDB - соединение для запросов только на чтение. Указывает на
+	// реплику, если задан READ_DB_PATH или READ_DSN, иначе совпадает с db.
+	readDB *sql.DB
+
+	stmtGetUser    *sql.Stmt
+	stmtReadUser   *sql.Stmt
+	stmtListUsers  *sql.Stmt
+	stmtInsertUser *sql.Stmt
+
+	// userReads объединяет одновременные чтения одного пользователя по ID
+	userReads singleflight.Group
+}
+
+// openStore открывает базу по dsn и реплику по readDSN (пустой readDSN -
+// чтение из той же базы), проверяет файл, применяет миграции и готовит
+// выражения. При ошибке открытые соединения закрываются.
+func openStore(driverName, dsn, readDSN string) (*store, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	st := &store{db: db, readDB: db}
+
+	if readDSN != "" {
+		st.readDB, err = sql.Open(driverName, readDSN)
+		if err != nil {
+			st.close()
+			return nil, fmt.Errorf("failed to open read database: %w", err)
+		}
+	}
+
+	// Проверка целостности файла базы данных
+	if err := st.check(); err != nil {
+		st.close()
+		return nil, err
+	}
+
+	// Создание таблиц и применение миграций схемы
+	if err := st.migrate(); err != nil {
+		st.close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	// Подготовка выражений для частых запросов
+	if err := st.prepare(); err != nil {
+		st.close()
+		return nil, fmt.Errorf("failed to prepare statements: %w", err)
+	}
+	return st, nil
+}
+
+// close закрывает подготовленные выражения и соединения
+func (st *store) close() error {
+	for _, stmt := range []*sql.Stmt{st.stmtGetUser, st.stmtReadUser, st.stmtListUsers, st.stmtInsertUser} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	if st.readDB != st.db {
+		st.readDB.Close()
+	}
+	return st.db.Close()
+}
+
+// probe выполняет проверочный запрос к таблице пользователей: база
+// открывается, схема на месте и запросы выполняются
+func (st *store) probe(ctx context.Context) error {
+	var n int
+	return st.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM (SELECT 1 FROM users LIMIT 1)").Scan(&n)
+}
+
+// check проверяет, что файл базы данных читается и не поврежден
+func (st *store) check() error {
+	var result string
+	if err := st.db.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return fmt.Errorf("database self-check failed: %v; check that the database file is a valid SQLite database or restore it from a backup", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("database integrity check failed: %s; restore the database file from a backup", result)
+	}
+
+	// Отсутствующая таблица будет создана заново миграциями
+	var tables int
+	if err := st.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'").Scan(&tables); err != nil {
+		return fmt.Errorf("database self-check failed: %v", err)
+	}
+	if tables == 0 {
+		log.Println("Users table not found, creating it")
+	}
+	return nil
+}
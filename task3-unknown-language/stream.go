@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// streamFlushRows - через сколько записей поток сбрасывается клиенту
+const streamFlushRows = 100
+
+// streamUsersHandler - потоковая выдача списка пользователей. Каждая строка
+// кодируется сразу после чтения, поэтому память не растет с размером
+// результата. Фильтры те же, что у GET /users, ответ - JSON-массив.
+func (s *Server) streamUsersHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.checkQueryParams(r, append([]string{"sort"}, filterUsersParams...)...); err != nil {
+		return err
+	}
+
+	filter, err := s.parseUserFilter(r)
+	if err != nil {
+		return err
+	}
+	orderBy, err := s.parseUserSort(r)
+	if err != nil {
+		return err
+	}
+
+	// Выгрузка останавливается на ABSOLUTE_MAX_RESULTS, если администратор
+	// не снял потолок; лишняя запись показывает, что потолок применен
+	limit := -1
+	if !s.maxResultsOverridden(r) {
+		limit = s.config.AbsoluteMaxResults + 1
+	}
+
+	var rows *sql.Rows
+	if filter.empty() && orderBy == defaultUserOrder {
+		rows, err = s.store.stmtListUsers.QueryContext(r.Context(), limit, 0)
+	} else {
+		rows, err = s.store.readDB.QueryContext(r.Context(),
+			"SELECT "+userColumns+" FROM users"+filter.where()+" ORDER BY "+orderBy+" LIMIT ?",
+			append(append([]interface{}{}, filter.args...), limit)...)
+	}
+	if err != nil {
+		return dbError(err, "Failed to fetch users")
+	}
+	defer rows.Close()
+
+	// Применение потолка известно только в конце потока, поэтому оно
+	// сообщается трейлером
+	// Длина потока заранее неизвестна, поэтому диапазоны не поддерживаются
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Trailer", "X-Results-Capped")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	enc := json.NewEncoder(w)
+	written := 0
+	capped := false
+	w.Write([]byte("["))
+	for rows.Next() {
+		if limit > 0 && written == s.config.AbsoluteMaxResults {
+			capped = true
+			break
+		}
+		user, err := scanUser(rows)
+		if err != nil {
+			// Статус уже отправлен: обрываем массив, клиент получит некорректный JSON
+			log.Printf("User stream aborted after %d rows: %v", written, err)
+			return nil
+		}
+		if written > 0 {
+			w.Write([]byte(","))
+		}
+		if err := enc.Encode(user); err != nil {
+			log.Printf("User stream aborted after %d rows: %v", written, err)
+			return nil
+		}
+		written++
+		if flusher != nil && written%streamFlushRows == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("User stream aborted after %d rows: %v", written, err)
+		return nil
+	}
+	w.Write([]byte("]\n"))
+	w.Header().Set("X-Results-Capped", strconv.FormatBool(capped))
+	return nil
+}
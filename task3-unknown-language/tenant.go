@@ -0,0 +1,240 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// tenantPattern - допустимый идентификатор арендатора; он же имя файла
+// базы, поэтому точки и разделители пути исключены
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// errTenantNotFound - база арендатора не создана, а TENANT_AUTO_CREATE выключен
+var errTenantNotFound = errors.New("tenant database does not exist")
+
+// tenantEntry - открытая база арендатора с собственным Server. refs -
+// количество запросов, которые сейчас ее используют: вытесненная из LRU
+// база закрывается только после завершения последнего из них.
+type tenantEntry struct {
+	id      string
+	ready   chan struct{}
+	server  *Server
+	handler http.Handler
+	err     error
+	refs    int
+	evicted bool
+}
+
+// tenantManager открывает базы арендаторов из TENANT_DIR при первом
+// обращении и держит не больше TENANT_MAX_OPEN открытых баз, закрывая
+// давно не использованные. У каждого арендатора свой Server: свои
+// подготовленные выражения, предохранитель и кеш статистики.
+type tenantManager struct {
+	base       *Server
+	driverName string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// newTenantManager создает менеджер баз арендаторов. Конфигурация и журнал
+// аудита берутся из base.
+func newTenantManager(base *Server, driverName string) *tenantManager {
+	return &tenantManager{
+		base:       base,
+		driverName: driverName,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// acquire возвращает базу арендатора, открывая ее при необходимости.
+// После обработки запроса нужно вызвать release.
+func (m *tenantManager) acquire(id string) (*tenantEntry, error) {
+	m.mu.Lock()
+	if elem, ok := m.entries[id]; ok {
+		m.lru.MoveToFront(elem)
+		entry := elem.Value.(*tenantEntry)
+		entry.refs++
+		m.mu.Unlock()
+
+		// База может еще открываться другим запросом
+		<-entry.ready
+		if entry.err != nil {
+			m.release(entry)
+			return nil, entry.err
+		}
+		return entry, nil
+	}
+
+	entry := &tenantEntry{id: id, ready: make(chan struct{}), refs: 1}
+	m.entries[id] = m.lru.PushFront(entry)
+	m.evict()
+	m.mu.Unlock()
+
+	// Открытие и миграции выполняются без блокировки, чтобы медленная база
+	// одного арендатора не задерживала запросы остальных
+	entry.server, entry.err = m.open(id)
+	if entry.err == nil {
+		entry.handler = entry.server.router()
+	} else {
+		m.mu.Lock()
+		if elem, ok := m.entries[id]; ok && elem.Value == entry {
+			m.lru.Remove(elem)
+			delete(m.entries, id)
+		}
+		m.mu.Unlock()
+	}
+	close(entry.ready)
+
+	if entry.err != nil {
+		m.release(entry)
+		return nil, entry.err
+	}
+	return entry, nil
+}
+
+// release отпускает базу после запроса и закрывает ее, если она вытеснена
+func (m *tenantManager) release(entry *tenantEntry) {
+	m.mu.Lock()
+	entry.refs--
+	closeNow := entry.refs == 0 && entry.evicted
+	m.mu.Unlock()
+
+	if closeNow && entry.server != nil {
+		entry.server.store.close()
+	}
+}
+
+// evict вытесняет давно не использованные базы сверх TENANT_MAX_OPEN.
+// Вызывается под m.mu.
+func (m *tenantManager) evict() {
+	for m.lru.Len() > m.base.config.TenantMaxOpen {
+		elem := m.lru.Back()
+		entry := elem.Value.(*tenantEntry)
+		m.lru.Remove(elem)
+		delete(m.entries, entry.id)
+		entry.evicted = true
+
+		// Незанятая база закрывается сразу, занятая - в release
+		if entry.refs == 0 && entry.server != nil {
+			entry.server.store.close()
+		}
+	}
+}
+
+// open открывает базу арендатора и применяет к ней миграции
+func (m *tenantManager) open(id string) (*Server, error) {
+	cfg := m.base.config
+	path := cfg.tenantPath(id)
+	if !cfg.TenantAutoCreate {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return nil, errTenantNotFound
+		}
+	}
+
+	st, err := openStore(m.driverName, cfg.tenantDSN(id), "")
+	if err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", id, err)
+	}
+	log.Printf("Opened database of tenant %s: %s", id, path)
+
+	s := newServer(cfg, st)
+	s.auditLog = m.base.auditLog
+	s.tenant = id
+	return s, nil
+}
+
+// close закрывает все открытые базы арендаторов; вызывается после
+// остановки HTTP-сервера
+func (m *tenantManager) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, elem := range m.entries {
+		if entry := elem.Value.(*tenantEntry); entry.server != nil {
+			entry.server.store.close()
+		}
+		m.lru.Remove(elem)
+		delete(m.entries, id)
+	}
+}
+
+// openCount возвращает количество открытых баз арендаторов
+func (m *tenantManager) openCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// tenantID определяет арендатора по заголовку TENANT_HEADER, а если он не
+// передан - по поддомену TENANT_DOMAIN (acme.api.example.com -> acme)
+func (s *Server) tenantID(r *http.Request) string {
+	if id := r.Header.Get(s.config.TenantHeader); id != "" {
+		return strings.ToLower(id)
+	}
+	if s.config.TenantDomain == "" {
+		return ""
+	}
+	host := s.externalHost(r)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+s.config.TenantDomain)
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// tenantMiddleware направляет запрос в роутер базы арендатора. /health и
+// /metrics относятся к процессу и обслуживаются основной базой.
+func (s *Server) tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := s.tenantID(r)
+		if id == "" {
+			s.writeError(w, r, apiError{
+				Status:  http.StatusBadRequest,
+				Code:    "tenant_required",
+				Message: fmt.Sprintf("Tenant is required: set the %s header", s.config.TenantHeader),
+			})
+			return
+		}
+		if !tenantPattern.MatchString(id) {
+			s.writeError(w, r, apiError{
+				Status:  http.StatusBadRequest,
+				Code:    "invalid_tenant",
+				Message: "Tenant must be 1-63 lowercase letters, digits or hyphens",
+			})
+			return
+		}
+
+		entry, err := s.tenants.acquire(id)
+		if errors.Is(err, errTenantNotFound) {
+			s.writeError(w, r, notFound("Tenant not found"))
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to open tenant database: %v", err)
+			s.writeError(w, r, dbError(err, "Failed to open tenant database"))
+			return
+		}
+		defer s.tenants.release(entry)
+
+		entry.handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedDriverName - драйвер SQLite, создающий спаны для запросов к базе
+const tracedDriverName = "sqlite3-otel"
+
+// tracer создает спаны запросов и обращений к базе. До вызова initTracing
+// глобальный провайдер пустой, и спаны ничего не стоят.
+var tracer = otel.Tracer("user-api")
+
+// initTracing настраивает экспорт спанов по OTLP/HTTP на адрес из
+// OTEL_EXPORTER_OTLP_ENDPOINT (экспортер читает его и остальные переменные
+// OTEL_EXPORTER_OTLP_* сам) и прием заголовка traceparent. Возвращает
+// функцию, отправляющую накопленные спаны при остановке.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "user-api"))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	sql.Register(tracedDriverName, tracedDriver{&sqlite3.SQLiteDriver{}})
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware создает спан на каждый запрос. Спан называется по
+// шаблону маршрута (GET /users/{id}), а не по пути, чтобы число имен не
+// росло с количеством пользователей. Входящий traceparent продолжает трассу.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// startDBSpan начинает дочерний спан обращения к базе
+func startDBSpan(ctx context.Context, operation, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "db."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.query.text", query),
+		),
+	)
+}
+
+// endDBSpan завершает спан обращения к базе, отмечая ошибку
+func endDBSpan(span trace.Span, err error) {
+	if err != nil && err != driver.ErrSkip {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedDriver оборачивает драйвер SQLite, чтобы каждый запрос с контекстом
+// становился дочерним спаном запроса HTTP. Ошибки драйвера возвращаются без
+// изменений, поэтому dbError по-прежнему распознает коды SQLite.
+type tracedDriver struct {
+	driver.Driver
+}
+
+// Open открывает соединение и оборачивает его
+func (d tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// tracedConn - соединение SQLite со спанами на запросы
+type tracedConn struct {
+	*sqlite3.SQLiteConn
+}
+
+// ExecContext выполняет запрос без результата в дочернем спане
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := startDBSpan(ctx, "exec", query)
+	result, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	endDBSpan(span, err)
+	return result, err
+}
+
+// QueryContext выполняет запрос в дочернем спане; спан покрывает выполнение,
+// но не чтение строк
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := startDBSpan(ctx, "query", query)
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	endDBSpan(span, err)
+	return rows, err
+}
+
+// PrepareContext подготавливает выражение, выполнение которого тоже трассируется
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{stmt.(*sqlite3.SQLiteStmt), query}, nil
+}
+
+// Prepare подготавливает выражение без контекста (database/sql вызывает его
+// через PrepareContext, метод нужен для интерфейса driver.Conn)
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// tracedStmt - подготовленное выражение со спанами на выполнение
+type tracedStmt struct {
+	*sqlite3.SQLiteStmt
+	query string
+}
+
+// ExecContext выполняет подготовленное выражение в дочернем спане
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := startDBSpan(ctx, "exec", s.query)
+	result, err := s.SQLiteStmt.ExecContext(ctx, args)
+	endDBSpan(span, err)
+	return result, err
+}
+
+// QueryContext выполняет подготовленный запрос в дочернем спане
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := startDBSpan(ctx, "query", s.query)
+	rows, err := s.SQLiteStmt.QueryContext(ctx, args)
+	endDBSpan(span, err)
+	return rows, err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiFS embed.FS
+
+// adminUIHandler отдает встроенный административный интерфейс из каталога
+// ui. Страницы открываются в браузере без заголовка Bearer, поэтому при
+// заданном ADMIN_TOKEN запрашивается Basic-авторизация с токеном в качестве
+// пароля. Интерфейс работает через обычные эндпоинты /users.
+func (s *Server) adminUIHandler() http.Handler {
+	static, err := fs.Sub(uiFS, "ui")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/admin/ui/", http.FileServer(http.FS(static)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken != "" && !s.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+			s.writeError(w, r, unauthorized("Admin authorization required"))
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
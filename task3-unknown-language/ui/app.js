@@ -0,0 +1,104 @@
+// Административный интерфейс: список, создание, изменение и удаление
+// пользователей через JSON API
+const form = document.getElementById("user-form");
+const saveButton = document.getElementById("save");
+const cancelButton = document.getElementById("cancel");
+const errorBox = document.getElementById("error");
+const tbody = document.getElementById("users");
+
+async function api(method, path, body) {
+  const response = await fetch(path, {
+    method,
+    headers: body ? { "Content-Type": "application/json" } : {},
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  const data = response.status === 204 ? null : await response.json();
+  if (!response.ok) {
+    const details = data && data.details ? ": " + data.details.join("; ") : "";
+    throw new Error((data && data.error ? data.error : response.statusText) + details);
+  }
+  return data;
+}
+
+function cell(text) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  return td;
+}
+
+function button(label, onClick) {
+  const b = document.createElement("button");
+  b.type = "button";
+  b.textContent = label;
+  b.addEventListener("click", onClick);
+  return b;
+}
+
+async function loadUsers() {
+  const data = await api("GET", "/users?count=none&limit=200");
+  tbody.replaceChildren(...data.users.map((user) => {
+    const tr = document.createElement("tr");
+    tr.append(cell(user.id), cell(user.name), cell(user.email), cell(user.username || ""), cell(user.age),
+      cell(user.status), cell(new Date(user.created_at).toLocaleString()));
+    const actions = document.createElement("td");
+    actions.append(
+      button("Edit", () => startEdit(user)),
+      button("Delete", () => removeUser(user)),
+    );
+    tr.append(actions);
+    return tr;
+  }));
+}
+
+function startEdit(user) {
+  form.id.value = user.id;
+  form.name.value = user.name;
+  form.email.value = user.email;
+  form.username.value = user.username || "";
+  form.age.value = user.age;
+  saveButton.textContent = "Save";
+  cancelButton.hidden = false;
+}
+
+function resetForm() {
+  form.reset();
+  form.id.value = "";
+  saveButton.textContent = "Create";
+  cancelButton.hidden = true;
+}
+
+async function removeUser(user) {
+  if (!confirm(`Delete ${user.name} <${user.email}>?`)) {
+    return;
+  }
+  await run(() => api("DELETE", `/users/${user.id}`));
+}
+
+async function run(action) {
+  errorBox.textContent = "";
+  try {
+    await action();
+    await loadUsers();
+  } catch (err) {
+    errorBox.textContent = err.message;
+  }
+}
+
+form.addEventListener("submit", (event) => {
+  event.preventDefault();
+  const user = {
+    name: form.name.value,
+    email: form.email.value,
+    username: form.username.value,
+    age: Number(form.age.value || 0),
+  };
+  const id = form.id.value;
+  run(async () => {
+    await (id ? api("PUT", `/users/${id}`, user) : api("POST", "/users", user));
+    resetForm();
+  });
+});
+
+cancelButton.addEventListener("click", resetForm);
+
+run(async () => {});
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// externalScheme возвращает схему, по которой клиент обратился к серверу.
+// X-Forwarded-Proto учитывается только при TRUST_PROXY, иначе схема
+// определяется по TLS соединения.
+func (s *Server) externalScheme(r *http.Request) string {
+	if s.config.TrustProxy {
+		if proto := firstForwardedValue(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// externalHost возвращает хост, по которому клиент обратился к серверу.
+// X-Forwarded-Host учитывается только при TRUST_PROXY.
+func (s *Server) externalHost(r *http.Request) string {
+	if s.config.TrustProxy {
+		if host := firstForwardedValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+			return host
+		}
+	}
+	return r.Host
+}
+
+// externalURL строит абсолютный URL для заголовков Location и Link
+func (s *Server) externalURL(r *http.Request, path string) string {
+	return s.externalScheme(r) + "://" + s.externalHost(r) + path
+}
+
+// firstForwardedValue возвращает первое значение из списка через запятую,
+// который добавляют цепочки прокси
+func firstForwardedValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.ToLower(strings.TrimSpace(first))
+}
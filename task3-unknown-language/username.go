@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Ограничения длины имени пользователя
+const (
+	minUsernameLen = 3
+	maxUsernameLen = 30
+)
+
+// usernamePattern - допустимые символы имени пользователя
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// reservedUsernames - имена, которые нельзя занять: они совпадают с
+// путями API или выдают себя за служебные учетные записи
+var reservedUsernames = []string{
+	"admin", "administrator", "api", "me", "null", "root", "support", "system",
+}
+
+// validateUsername проверяет необязательное имя пользователя.
+// Пустая строка означает, что имя не задано.
+func validateUsername(username string) []message {
+	if username == "" {
+		return nil
+	}
+
+	var errors []message
+	if len(username) < minUsernameLen {
+		errors = append(errors, newMessage("username_too_short", minUsernameLen))
+	}
+	if len(username) > maxUsernameLen {
+		errors = append(errors, newMessage("username_too_long", maxUsernameLen))
+	}
+	if !usernamePattern.MatchString(username) {
+		errors = append(errors, newMessage("username_invalid"))
+	} else if slices.Contains(reservedUsernames, username) {
+		errors = append(errors, newMessage("username_reserved", username))
+	}
+	return errors
+}
+
+// uniqueConflict возвращает 409 для нарушения уникальности: занятое имя
+// пользователя сообщается отдельно от занятого email
+func uniqueConflict(err error) apiError {
+	if strings.Contains(err.Error(), "users.username") {
+		return apiError{Status: http.StatusConflict, Code: "username_taken", Message: "Username already exists"}
+	}
+	return conflict("Email already exists")
+}
+
+// getUserByUsernameHandler - поиск пользователя по имени без учета регистра
+func (s *Server) getUserByUsernameHandler(w http.ResponseWriter, r *http.Request) error {
+	user, err := scanUser(s.store.readDB.QueryRowContext(r.Context(),
+		"SELECT "+userColumns+" FROM users WHERE username = ? COLLATE NOCASE AND "+notDeleted,
+		mux.Vars(r)["username"]))
+	if err == sql.ErrNoRows {
+		return notFound("User not found")
+	}
+	if err != nil {
+		return dbError(err, "Failed to fetch user")
+	}
+
+	writeJSON(w, http.StatusOK, s.withComputedFields(user, time.Now()))
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// EmailValidationRequest - тело запроса POST /validate/email
+type EmailValidationRequest struct {
+	Email string `json:"email"`
+}
+
+// EmailValidationResult - результат проверки email без создания пользователя
+type EmailValidationResult struct {
+	Valid      bool   `json:"valid"`
+	Normalized string `json:"normalized"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// validateEmailHandler - проверка и нормализация email для форм, которые
+// проверяют адрес до остальных полей. Email обрезается и приводится к нижнему
+// регистру, затем проверяется теми же правилами, что и при создании
+// пользователя. Таблица users не читается, уникальность не проверяется.
+func (s *Server) validateEmailHandler(w http.ResponseWriter, r *http.Request) error {
+	body, err := s.readBody(w, r)
+	if err != nil {
+		return err
+	}
+	var req EmailValidationRequest
+	if err := s.unmarshalBody(body, &req); err != nil {
+		return err
+	}
+	if fields := invalidUTF8Fields(body); len(fields) > 0 {
+		return invalidEncoding([]message{newMessage("email_invalid_utf8")})
+	}
+
+	normalized := strings.ToLower(strings.TrimSpace(req.Email))
+	result := EmailValidationResult{Valid: true, Normalized: normalized}
+	if problems := s.validateEmail(normalized); len(problems) > 0 {
+		result.Valid = false
+		result.Reason = translate(requestLang(r), problems[0])
+	}
+
+	writeJSON(w, http.StatusOK, result)
+	return nil
+}
+
+// maxValidateBatchRows - максимальное количество строк в /users/validate-batch
+const maxValidateBatchRows = 1000
+
+// RowValidationResult - результат проверки одной строки пакета
+type RowValidationResult struct {
+	Index  int      `json:"index"`
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+}
+
+// validateBatchHandler - проверка массива пользователей перед импортом.
+// Каждая строка проходит тот же разбор и валидацию, что и при создании,
+// дополнительно отмечаются email, повторяющие более раннюю строку пакета.
+// Ничего не сохраняется, уникальность относительно базы не проверяется.
+func (s *Server) validateBatchHandler(w http.ResponseWriter, r *http.Request) error {
+	body, err := s.readJSONArray(w, r)
+	if err != nil {
+		return err
+	}
+
+	// Строки декодируются по одной; в памяти остаются только результаты
+	lang := requestLang(r)
+	seen := make(map[string]int)
+	var results []RowValidationResult
+	invalid := 0
+	count, err := eachJSONArrayItem(body, func(i int, row json.RawMessage) error {
+		if i >= maxValidateBatchRows {
+			return badRequest(fmt.Sprintf("Batch must contain at most %d rows", maxValidateBatchRows))
+		}
+		result := RowValidationResult{Index: i, Errors: []string{}}
+
+		userReq, err := s.parseUserRequest(row)
+		if err != nil {
+			result.Errors = append(result.Errors, rowErrors(lang, err)...)
+		}
+		if email := strings.ToLower(strings.TrimSpace(userReq.Email)); email != "" {
+			if first, ok := seen[email]; ok {
+				result.Errors = append(result.Errors, translate(lang, newMessage("email_duplicate_in_batch", first)))
+			} else {
+				seen[email] = i
+			}
+		}
+
+		result.Valid = len(result.Errors) == 0
+		if !result.Valid {
+			invalid++
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return badRequest("Batch must contain at least one row")
+	}
+
+	w.Header().Set("Content-Language", lang)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"valid":   count - invalid,
+		"invalid": invalid,
+	})
+	return nil
+}
+
+// rowErrors переводит ошибку разбора строки в список сообщений
+func rowErrors(lang string, err error) []string {
+	apiErr, ok := err.(apiError)
+	if !ok {
+		return []string{err.Error()}
+	}
+	if len(apiErr.messages) == 0 {
+		return []string{translateError(lang, apiErr.Message)}
+	}
+	errors := make([]string, 0, len(apiErr.messages))
+	for _, m := range apiErr.messages {
+		errors = append(errors, translate(lang, m))
+	}
+	return errors
+}